@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/applog"
+	"github.com/lkimju1/v2n-coremesh/internal/bindmode"
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"github.com/lkimju1/v2n-coremesh/internal/share"
+	"github.com/urfave/cli/v2"
+)
+
+// bindOptions reads --bind, --bind-all, --bind-scope, --lan-auth and
+// --lan-allow; nil means no listener is rewritten.
+func bindOptions(c *cli.Context, confDir string) (*bindmode.Options, error) {
+	host := strings.TrimSpace(c.String("bind"))
+	lanAuth := c.Bool("lan-auth")
+	allowList := c.StringSlice("lan-allow")
+	if host == "" && !c.Bool("bind-all") {
+		if lanAuth || len(allowList) > 0 {
+			return nil, fmt.Errorf("--lan-auth and --lan-allow require --bind or --bind-all")
+		}
+		return nil, nil
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	host, err := bindmode.ParseHost(host)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := bindmode.ParseScope(c.String("bind-scope"))
+	if err != nil {
+		return nil, err
+	}
+	opts := &bindmode.Options{Host: host, Scope: scope}
+	if (lanAuth || len(allowList) > 0) && scope == bindmode.ScopeCores {
+		return nil, fmt.Errorf("--lan-auth and --lan-allow protect xray's inbounds; use --bind-scope xray or all")
+	}
+	if lanAuth {
+		creds, _, err := bindmode.LoadOrCreateCredentials(confDir)
+		if err != nil {
+			return nil, err
+		}
+		opts.Credentials = creds
+	}
+	if opts.Allow, err = bindmode.ParseAllowList(allowList); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func describeBind(bind *bindmode.Options) string {
+	if bind == nil {
+		return "off"
+	}
+	return fmt.Sprintf("%s(%s) auth=%t allow=%d", bind.Host, bind.Scope, bind.Credentials != nil, len(bind.Allow))
+}
+
+// printLANAccess tells the user how LAN clients reach xray. The password is
+// printed to the terminal only, not to the log file.
+func printLANAccess(cfg *config.File, bind *bindmode.Options, confDir string, logger *applog.Logger) {
+	if bind.Scope == bindmode.ScopeCores {
+		return
+	}
+	inbounds, err := bindmode.Inbounds(cfg.App.GeneratedXrayConfig)
+	if err != nil {
+		logger.Printf("list lan inbounds failed: %v", err)
+		return
+	}
+	fmt.Println("LAN access:")
+	for _, in := range inbounds {
+		for _, host := range share.Hosts(in.Listen) {
+			fmt.Printf("  %-6s %s\n", in.Protocol, net.JoinHostPort(host, strconv.Itoa(in.Port)))
+		}
+	}
+	if bind.Credentials != nil {
+		fmt.Printf("  username: %s\n  password: %s\n  (stored in %s/%s)\n",
+			bind.Credentials.Username, bind.Credentials.Password, confDir, bindmode.CredentialsFileName)
+	} else {
+		fmt.Println("  no authentication (enable with --lan-auth)")
+	}
+	if len(bind.Allow) > 0 {
+		allowed := make([]string, 0, len(bind.Allow))
+		for _, p := range bind.Allow {
+			allowed = append(allowed, p.String())
+		}
+		fmt.Printf("  allowed clients: %s and loopback\n", strings.Join(allowed, ", "))
+	}
+}
+
+// printShareLinks prints the URIs and QR codes of cfg's LAN-reachable
+// inbounds.
+func printShareLinks(w io.Writer, xrayConfig, style string) error {
+	links, err := share.Links(xrayConfig)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		_, err := fmt.Fprintln(w, "no inbound is reachable from the LAN; run with --bind-all or --bind <ip>")
+		return err
+	}
+	return share.Print(w, links, style)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lkimju1/v2n-coremesh/internal/runner"
+	"github.com/urfave/cli/v2"
+)
+
+func runStatus(c *cli.Context) error {
+	client, err := runner.NewControlClient(strings.TrimSpace(c.String("conf-dir")))
+	if err != nil {
+		return err
+	}
+	st, err := client.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("session started at %s\n", st.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tPID\tUPTIME\tRESTARTS")
+	for _, p := range st.Processes {
+		pid := "-"
+		if p.PID > 0 {
+			pid = fmt.Sprint(p.PID)
+		}
+		uptime := p.Uptime
+		if uptime == "" {
+			uptime = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", p.Name, p.State, pid, uptime, p.Restarts)
+	}
+	return w.Flush()
+}
+
+func runRestart(c *cli.Context) error {
+	name := strings.TrimSpace(c.Args().First())
+	if name == "" {
+		return fmt.Errorf("usage: restart <tag|xray>")
+	}
+	client, err := runner.NewControlClient(strings.TrimSpace(c.String("conf-dir")))
+	if err != nil {
+		return err
+	}
+	return client.Restart(name)
+}
+
+func runReload(c *cli.Context) error {
+	client, err := runner.NewControlClient(strings.TrimSpace(c.String("conf-dir")))
+	if err != nil {
+		return err
+	}
+	return client.Reload()
+}
+
+func runStop(c *cli.Context) error {
+	client, err := runner.NewControlClient(strings.TrimSpace(c.String("conf-dir")))
+	if err != nil {
+		return err
+	}
+	return client.Stop()
+}
+
+// sessionXrayConfig returns the xray config of the running session, such as
+// the bind-mode copy with the LAN listen address and accounts, or fallback
+// when no session answers.
+func sessionXrayConfig(confDir, fallback string) string {
+	if client, err := runner.NewControlClient(confDir); err == nil {
+		if st, err := client.Status(); err == nil && st.XrayConfig != "" {
+			return st.XrayConfig
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/state"
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
+	"github.com/urfave/cli/v2"
+)
+
+func runEnv(c *cli.Context) error {
+	confDir := strings.TrimSpace(c.String("conf-dir"))
+	stateFile, err := state.Load(confDir)
+	if err != nil {
+		return err
+	}
+	ep, err := sysproxy.DetectProxyEndpoint(sessionXrayConfig(confDir, stateFile.Config.App.GeneratedXrayConfig))
+	if err != nil {
+		return err
+	}
+	out, err := sysproxy.FormatEnv(strings.ToLower(strings.TrimSpace(c.String("format"))), sysproxy.ProxyEnv(ep))
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -12,9 +13,12 @@ import (
 	"github.com/lkimju1/v2n-coremesh/internal/applog"
 	"github.com/lkimju1/v2n-coremesh/internal/assets"
 	"github.com/lkimju1/v2n-coremesh/internal/bindmode"
+	"github.com/lkimju1/v2n-coremesh/internal/chain"
 	"github.com/lkimju1/v2n-coremesh/internal/config"
 	"github.com/lkimju1/v2n-coremesh/internal/runner"
+	"github.com/lkimju1/v2n-coremesh/internal/share"
 	"github.com/lkimju1/v2n-coremesh/internal/state"
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
 	"github.com/lkimju1/v2n-coremesh/internal/v2raynimport"
 	"github.com/lkimju1/v2n-coremesh/internal/validate"
 	"github.com/lkimju1/v2n-coremesh/internal/xraygen"
@@ -29,21 +33,30 @@ func main() {
 			{
 				Name:    "parse",
 				Aliases: []string{"p"},
-				Usage:   "parse v2rayN and generate runtime files",
+				Usage:   "parse v2rayN or a standalone config and generate runtime files",
 				Action:  runParse,
 				Flags: []cli.Flag{
+					confDirFlag(),
 					&cli.StringFlag{
-						Name:    "conf-dir",
-						Aliases: []string{"c"},
-						Usage:   "config directory",
-						Value:   defaultConfDir(),
+						Name:    "v2rayn-home",
+						Aliases: []string{"v"},
+						Usage:   "v2rayN home path",
 					},
 					&cli.StringFlag{
-						Name:     "v2rayn-home",
-						Aliases:  []string{"v"},
-						Usage:    "v2rayN home path",
-						Required: true,
+						Name:    "config",
+						Aliases: []string{"f"},
+						Usage:   "standalone config.yaml (used instead of v2rayn-home)",
 					},
+					&cli.IntFlag{
+						Name:  "core-inbounds",
+						Usage: "add one xray inbound per core on consecutive ports from this base port (0 keeps the config's core_inbounds)",
+					},
+					&cli.StringFlag{
+						Name:  "core-inbounds-protocol",
+						Usage: "protocol of the per-core inbounds: socks or mixed",
+						Value: "socks",
+					},
+					modeFlag(),
 				},
 			},
 			{
@@ -52,19 +65,148 @@ func main() {
 				Usage:   "run all cores and xray",
 				Action:  runRun,
 				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "conf-dir",
-						Aliases: []string{"c"},
-						Usage:   "config directory",
-						Value:   defaultConfDir(),
-					},
+					confDirFlag(),
 					&cli.BoolFlag{
 						Name:    "bind-all",
 						Aliases: []string{"a"},
-						Usage:   "bind xray and core listen addresses to 0.0.0.0 for LAN access",
+						Usage:   "listen on 0.0.0.0 for LAN access (same as --bind 0.0.0.0)",
+					},
+					&cli.StringFlag{
+						Name:  "bind",
+						Usage: "listen on this address for LAN access: an interface IP, 0.0.0.0 or ::",
+					},
+					&cli.StringFlag{
+						Name:  "bind-scope",
+						Usage: "which listeners --bind/--bind-all rewrites: xray, cores or all",
+						Value: string(bindmode.ScopeXray),
+					},
+					&cli.BoolFlag{
+						Name:  "lan-auth",
+						Usage: "require the account in <conf-dir>/lan_auth.json (generated on first use) on xray's exposed inbounds",
+					},
+					&cli.StringSliceFlag{
+						Name:  "lan-allow",
+						Usage: "only accept clients from these CIDRs or IPs on xray's exposed inbounds (loopback is always allowed)",
+					},
+					&cli.DurationFlag{
+						Name:  "asset-check-interval",
+						Usage: "how often to check geo assets for staleness while running (0 disables)",
+						Value: time.Hour,
+					},
+					&cli.BoolFlag{
+						Name:  "offline",
+						Usage: "do not download geo assets; accept stale files with a warning",
+					},
+					&cli.StringFlag{
+						Name:  "pac-listen",
+						Usage: "serve a PAC file generated from the routing rules on this address (e.g. 127.0.0.1:10890)",
+					},
+					&cli.BoolFlag{
+						Name:  "share",
+						Usage: "print LAN proxy URIs and QR codes at startup",
+					},
+					&cli.BoolFlag{
+						Name:    "watch",
+						Aliases: []string{"w"},
+						Usage:   "reload when coremesh.state.json changes (e.g. after parse)",
+					},
+					modeFlag(),
+				},
+			},
+			{
+				Name:      "route",
+				Usage:     "explain which routing rule and outbound a destination hits",
+				ArgsUsage: "<domain|ip[:port]>",
+				Action:    runRoute,
+				Flags: []cli.Flag{
+					confDirFlag(),
+					&cli.StringFlag{
+						Name:  "network",
+						Usage: "network of the simulated connection (tcp or udp)",
+						Value: "tcp",
+					},
+					&cli.StringFlag{
+						Name:  "inbound",
+						Usage: "inbound tag of the simulated connection",
 					},
 				},
 			},
+			{
+				Name:   "env",
+				Usage:  "print proxy environment variables pointing at the xray inbound",
+				Action: runEnv,
+				Flags: []cli.Flag{
+					confDirFlag(),
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format: " + strings.Join(sysproxy.EnvFormats, ", "),
+						Value: "sh",
+					},
+				},
+			},
+			{
+				Name:      "mode",
+				Usage:     "show or switch the routing mode, reloading a running session",
+				ArgsUsage: "[" + strings.Join(config.Modes, "|") + "]",
+				Action:    runMode,
+				Flags:     []cli.Flag{confDirFlag()},
+			},
+			{
+				Name:   "share",
+				Usage:  "print LAN proxy URIs and QR codes for phones and other devices",
+				Action: runShare,
+				Flags: []cli.Flag{
+					confDirFlag(),
+					&cli.StringFlag{
+						Name:  "qr",
+						Usage: "QR code style: unicode, ascii or none",
+						Value: share.QRUnicode,
+					},
+				},
+			},
+			{
+				Name:  "sysproxy",
+				Usage: "manage system proxy settings changed by run",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "restore",
+						Usage:  "restore system proxy settings left behind by a session that did not exit cleanly",
+						Action: runSysproxyRestore,
+						Flags: []cli.Flag{
+							confDirFlag(),
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "restore even if the recorded session still appears to be running",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:   "status",
+				Usage:  "show processes of the running session",
+				Action: runStatus,
+				Flags:  []cli.Flag{confDirFlag()},
+			},
+			{
+				Name:      "restart",
+				Usage:     "restart one core (by outbound tag) or xray in the running session",
+				ArgsUsage: "<tag|xray>",
+				Action:    runRestart,
+				Flags:     []cli.Flag{confDirFlag()},
+			},
+			{
+				Name:   "reload",
+				Usage:  "re-parse sources and reload the running session",
+				Action: runReload,
+				Flags:  []cli.Flag{confDirFlag()},
+			},
+			{
+				Name:   "stop",
+				Usage:  "stop the running session",
+				Action: runStop,
+				Flags:  []cli.Flag{confDirFlag()},
+			},
 		},
 	}
 
@@ -76,75 +218,172 @@ func main() {
 func runParse(c *cli.Context) error {
 	confDir := strings.TrimSpace(c.String("conf-dir"))
 	v2raynHome := strings.TrimSpace(c.String("v2rayn-home"))
+	configPath := strings.TrimSpace(c.String("config"))
+	if (v2raynHome == "") == (configPath == "") {
+		return fmt.Errorf("exactly one of -v2rayn-home or -config is required")
+	}
 
 	logger, err := applog.New(confDir)
 	if err != nil {
 		return err
 	}
 	defer logger.Close()
-	logger.Printf("command=parse conf_dir=%s v2rayn_home=%s", confDir, v2raynHome)
+	logger.Printf("command=parse conf_dir=%s v2rayn_home=%s config=%s", confDir, v2raynHome, configPath)
 
-	mainCfg, routingCfg, err := v2raynimport.LoadFromHome(v2raynHome)
+	opts := state.Options{Mode: strings.ToLower(strings.TrimSpace(c.String("mode")))}
+	if err := validate.CheckMode(opts.Mode); err != nil {
+		return err
+	}
+	if port := c.Int("core-inbounds"); port != 0 {
+		opts.CoreInbounds = &config.CoreInbounds{
+			BasePort: port,
+			Protocol: strings.ToLower(strings.TrimSpace(c.String("core-inbounds-protocol"))),
+		}
+	}
+
+	mainCfg, routingCfg, err := parseSources(confDir, v2raynHome, configPath, opts, logger)
 	if err != nil {
-		logger.Printf("parse failed: %v", err)
 		return err
 	}
+	for _, sk := range mainCfg.Skipped {
+		fmt.Fprintf(os.Stderr, "warning: profile %s(%s) not imported: %s\n", sk.ProfileID, sk.Name, sk.Reason)
+	}
+	for _, sk := range routingCfg.Skipped {
+		fmt.Fprintf(os.Stderr, "warning: routing rule #%d %q not mapped: %s\n", sk.Index+1, sk.Name, sk.Reason)
+	}
+	return nil
+}
 
+// parseSources runs the parse pipeline for either a v2rayN home or a
+// standalone config file, applies opts and saves the resulting state.
+func parseSources(confDir, v2raynHome, configPath string, opts state.Options, logger *applog.Logger) (*config.File, *config.Routing, error) {
+	var mainCfg *config.File
+	var routingCfg *config.Routing
+	var err error
+	if configPath != "" {
+		mainCfg, routingCfg, err = config.LoadStandalone(configPath)
+	} else {
+		mainCfg, routingCfg, err = v2raynimport.LoadFromHome(v2raynHome)
+	}
+	if err != nil {
+		logger.Printf("parse failed: %v", err)
+		return nil, nil, err
+	}
+	for _, sk := range mainCfg.Skipped {
+		logger.Printf("profile %s(%s) not imported: %s", sk.ProfileID, sk.Name, sk.Reason)
+	}
+	for _, sk := range routingCfg.Skipped {
+		logger.Printf("routing rule #%d %q not mapped: %s", sk.Index+1, sk.Name, sk.Reason)
+	}
+
+	if opts.CoreInbounds != nil {
+		mainCfg.CoreInbounds = opts.CoreInbounds
+	}
+	if opts.Mode != "" {
+		mainCfg.Mode = opts.Mode
+	}
 	mainCfg.App.WorkDir = confDir
 	mainCfg.App.GeneratedXrayConfig = filepath.Join(confDir, "xray.generated.json")
 
 	customRules, err := config.LoadCustomRules(filepath.Join(confDir, "custom_rules.yaml"))
 	if err != nil {
 		logger.Printf("load custom rules failed: %v", err)
-		return err
+		return nil, nil, err
+	}
+	groups, probe, err := config.LoadGroups(filepath.Join(confDir, "groups.yaml"))
+	if err != nil {
+		logger.Printf("load groups failed: %v", err)
+		return nil, nil, err
+	}
+	mainCfg.Groups = append(mainCfg.Groups, groups...)
+	if probe != (config.Probe{}) {
+		mainCfg.Probe = probe
 	}
 	if err := validate.Main(mainCfg, routingCfg); err != nil {
 		logger.Printf("validate failed: %v", err)
-		return err
+		return nil, nil, err
+	}
+	if err := validate.GeoReferences(confDir, routingCfg, customRules); err != nil {
+		logger.Printf("validate geo references failed: %v", err)
+		return nil, nil, err
 	}
 	if err := xraygen.Generate(mainCfg, routingCfg, customRules); err != nil {
 		logger.Printf("generate xray config failed: %v", err)
-		return err
+		return nil, nil, err
+	}
+	stateFile := state.New(v2raynHome, mainCfg)
+	if stateFile.Rules.CoreInbounds, err = xraygen.PinnedRules(mainCfg); err != nil {
+		return nil, nil, err
 	}
-	if err := state.Save(confDir, state.New(v2raynHome, mainCfg)); err != nil {
+	if mainCfg.Mode == "" || mainCfg.Mode == config.ModeRule {
+		stateFile.Rules.Custom = len(customRules)
+		stateFile.Rules.Imported = len(routingCfg.Rules)
+		stateFile.Rules.Fallback = routingCfg.DefaultOutboundTag != ""
+	} else {
+		stateFile.Rules.Fallback = true
+	}
+	stateFile.Options = opts
+	if configPath != "" {
+		if abs, err := filepath.Abs(configPath); err == nil {
+			configPath = abs
+		}
+		stateFile.ConfigFile = configPath
+	}
+	if err := state.Save(confDir, stateFile); err != nil {
 		logger.Printf("save state failed: %v", err)
-		return err
+		return nil, nil, err
 	}
 	logger.Printf("generated xray config: %s", mainCfg.App.GeneratedXrayConfig)
 	logger.Printf("state file: %s", state.Path(confDir))
 	logger.Printf("parsed cores: %d", len(mainCfg.Cores))
-	return nil
+	return mainCfg, routingCfg, nil
 }
 
 func runRun(c *cli.Context) error {
 	confDir := strings.TrimSpace(c.String("conf-dir"))
-	bindAll := c.Bool("bind-all")
-
-	logger, err := applog.New(confDir)
+	bind, err := bindOptions(c, confDir)
 	if err != nil {
 		return err
 	}
-	defer logger.Close()
-	logger.Printf("command=run conf_dir=%s bind_all=%t", confDir, bindAll)
+	watch := c.Bool("watch")
+	offline := c.Bool("offline")
 
-	stateFile, err := state.Load(confDir)
+	logger, err := applog.New(confDir)
 	if err != nil {
-		logger.Printf("load state failed: %v", err)
 		return err
 	}
-	cfg := &stateFile.Config
-	cfg.App.WorkDir = confDir
+	defer logger.Close()
+	logger.Printf("command=run conf_dir=%s bind=%s watch=%t offline=%t", confDir, describeBind(bind), watch, offline)
 
-	if bindAll {
-		cfg, err = bindmode.PrepareForBindAll(cfg, confDir)
-		if err != nil {
-			logger.Printf("prepare bind-all runtime config failed: %v", err)
+	if mode := strings.ToLower(strings.TrimSpace(c.String("mode"))); mode != "" {
+		if err := switchMode(confDir, mode, logger); err != nil {
 			return err
 		}
-		logger.Printf("bind-all enabled, runtime xray config: %s", cfg.App.GeneratedXrayConfig)
 	}
 
-	if err := assets.EnsureGeoFiles(confDir, time.Now()); err != nil {
+	cfg, err := loadRunConfig(confDir, bind, logger)
+	if err != nil {
+		return err
+	}
+	if bind != nil {
+		printLANAccess(cfg, bind, confDir, logger)
+	}
+	if c.Bool("share") {
+		if err := printShareLinks(os.Stdout, cfg.App.GeneratedXrayConfig, share.QRUnicode); err != nil {
+			logger.Printf("print share links failed: %v", err)
+		}
+	}
+	assetList, err := config.LoadAssets(filepath.Join(confDir, "assets.yaml"))
+	if err != nil {
+		logger.Printf("load assets config failed: %v", err)
+		return err
+	}
+	geoOpts := assets.Options{Assets: assetList, Logf: logger.Printf, Offline: offline}
+	if stateFile, err := state.Load(confDir); err == nil && stateFile.V2rayNHome != "" {
+		geoOpts.FallbackDir = filepath.Join(stateFile.V2rayNHome, "bin")
+	}
+	geoReport, err := assets.EnsureGeoFiles(confDir, time.Now(), geoOpts)
+	if err != nil {
 		logger.Printf("ensure geo files failed: %v", err)
 		return err
 	}
@@ -152,9 +391,87 @@ func runRun(c *cli.Context) error {
 		logger.Printf("validate run config failed: %v", err)
 		return err
 	}
+	load := func() (*config.File, error) {
+		next, err := loadRunConfig(confDir, bind, logger)
+		if err != nil {
+			return nil, err
+		}
+		if err := validate.ForRun(next); err != nil {
+			logger.Printf("validate run config failed: %v", err)
+			return nil, err
+		}
+		return next, nil
+	}
+	reload := func() (*config.File, error) {
+		stateFile, err := state.Load(confDir)
+		if err != nil {
+			logger.Printf("load state failed: %v", err)
+			return nil, err
+		}
+		if stateFile.V2rayNHome != "" || stateFile.ConfigFile != "" {
+			if _, _, err := parseSources(confDir, stateFile.V2rayNHome, stateFile.ConfigFile, stateFile.Options, logger); err != nil {
+				return nil, err
+			}
+		}
+		return load()
+	}
+	opts := runner.Options{
+		AssetDir:   confDir,
+		Logger:     logger,
+		ControlDir: confDir,
+		Reload:     reload,
+		Load:       load,
+		PACListen:  strings.TrimSpace(c.String("pac-listen")),
+	}
+	if watch {
+		opts.WatchFile = state.Path(confDir)
+	}
+	if !offline {
+		opts.RefreshAssets = func(proxy *url.URL) (bool, error) {
+			meshOpts := geoOpts
+			meshOpts.Proxy = proxy
+			meshOpts.FallbackDir = ""
+			report, err := assets.EnsureGeoFiles(confDir, time.Now(), meshOpts)
+			return len(report.Updated) > 0, err
+		}
+		opts.RefreshAssetsOnStart = len(geoReport.Stale) > 0
+		opts.RefreshInterval = c.Duration("asset-check-interval")
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
-	return runner.RunWithAssetDir(ctx, cfg, confDir, logger)
+	return runner.RunWithOptions(ctx, cfg, opts)
+}
+
+// loadRunConfig loads the parsed state and applies runtime-only rewrites.
+func loadRunConfig(confDir string, bind *bindmode.Options, logger *applog.Logger) (*config.File, error) {
+	stateFile, err := state.Load(confDir)
+	if err != nil {
+		logger.Printf("load state failed: %v", err)
+		return nil, err
+	}
+	cfg := &stateFile.Config
+	cfg.App.WorkDir = confDir
+
+	if bind != nil {
+		cfg, err = bindmode.Prepare(cfg, confDir, *bind)
+		if err != nil {
+			logger.Printf("prepare bind runtime config failed: %v", err)
+			return nil, err
+		}
+		logger.Printf("bind %s enabled for %s, runtime xray config: %s", bind.Host, bind.Scope, cfg.App.GeneratedXrayConfig)
+	}
+	chained, err := chain.Prepare(cfg, confDir)
+	if err != nil {
+		logger.Printf("prepare core chains failed: %v", err)
+		return nil, err
+	}
+	for i, c := range chained.Cores {
+		if c.Config != cfg.Cores[i].Config {
+			logger.Printf("core %s chained, runtime config: %s", c.Name, c.Config)
+		}
+	}
+	return chained, nil
 }
 
 func exitErr(err error) {
@@ -162,6 +479,15 @@ func exitErr(err error) {
 	os.Exit(1)
 }
 
+func confDirFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "conf-dir",
+		Aliases: []string{"c"},
+		Usage:   "config directory",
+		Value:   defaultConfDir(),
+	}
+}
+
 func defaultConfDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil || home == "" {
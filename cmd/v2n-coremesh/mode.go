@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/applog"
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"github.com/lkimju1/v2n-coremesh/internal/runner"
+	"github.com/lkimju1/v2n-coremesh/internal/state"
+	"github.com/lkimju1/v2n-coremesh/internal/validate"
+	"github.com/urfave/cli/v2"
+)
+
+func modeFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "mode",
+		Usage: "routing mode: " + strings.Join(config.Modes, ", ") + " (default: the config's mode, else rule)",
+	}
+}
+
+func runMode(c *cli.Context) error {
+	confDir := strings.TrimSpace(c.String("conf-dir"))
+	mode := strings.ToLower(strings.TrimSpace(c.Args().First()))
+	if mode == "" {
+		stateFile, err := state.Load(confDir)
+		if err != nil {
+			return err
+		}
+		current := stateFile.Config.Mode
+		if current == "" {
+			current = config.ModeRule
+		}
+		fmt.Println(current)
+		return nil
+	}
+
+	logger, err := applog.New(confDir)
+	if err != nil {
+		return err
+	}
+	defer logger.Close()
+	logger.Printf("command=mode conf_dir=%s mode=%s", confDir, mode)
+
+	if err := switchMode(confDir, mode, logger); err != nil {
+		return err
+	}
+	client, err := runner.NewControlClient(confDir)
+	if err != nil {
+		fmt.Printf("routing mode set to %s; it applies from the next run\n", mode)
+		return nil
+	}
+	if err := client.Reload(); err != nil {
+		return fmt.Errorf("routing mode saved, but reloading the session failed: %w", err)
+	}
+	fmt.Printf("routing mode set to %s\n", mode)
+	return nil
+}
+
+// switchMode re-parses the recorded sources with the new routing mode, which
+// is then kept in the state for later reloads.
+func switchMode(confDir, mode string, logger *applog.Logger) error {
+	if err := validate.CheckMode(mode); err != nil {
+		return err
+	}
+	stateFile, err := state.Load(confDir)
+	if err != nil {
+		return err
+	}
+	if stateFile.V2rayNHome == "" && stateFile.ConfigFile == "" {
+		return fmt.Errorf("state has no recorded sources, run parse first")
+	}
+	opts := stateFile.Options
+	opts.Mode = mode
+	_, _, err = parseSources(confDir, stateFile.V2rayNHome, stateFile.ConfigFile, opts, logger)
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/routesim"
+	"github.com/lkimju1/v2n-coremesh/internal/state"
+	"github.com/urfave/cli/v2"
+)
+
+func runRoute(c *cli.Context) error {
+	confDir := strings.TrimSpace(c.String("conf-dir"))
+	arg := strings.TrimSpace(c.Args().First())
+	if arg == "" {
+		return fmt.Errorf("usage: route <domain|ip[:port]>")
+	}
+	target, err := routesim.ParseTarget(arg)
+	if err != nil {
+		return err
+	}
+	target.Network = strings.ToLower(strings.TrimSpace(c.String("network")))
+	target.InboundTag = strings.TrimSpace(c.String("inbound"))
+
+	stateFile, err := state.Load(confDir)
+	if err != nil {
+		return err
+	}
+	origins := routesim.Origins{
+		CoreInbounds:  stateFile.Rules.CoreInbounds,
+		Custom:        stateFile.Rules.Custom,
+		Imported:      stateFile.Rules.Imported,
+		ImportedLabel: "v2rayN",
+		Fallback:      stateFile.Rules.Fallback,
+	}
+	if stateFile.ConfigFile != "" {
+		origins.ImportedLabel = "routing file"
+	}
+	sim, err := routesim.Load(stateFile.Config.App.GeneratedXrayConfig, confDir, origins)
+	if err != nil {
+		return err
+	}
+	res, err := sim.Route(target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("target:   %s\n", target)
+	if res.Index < 0 {
+		fmt.Println("rule:     none matched")
+		fmt.Printf("outbound: %s (first outbound)\n", sim.DefaultOutbound())
+	} else {
+		rule, _ := json.Marshal(res.Rule)
+		fmt.Printf("rule:     #%d (%s) %s\n", res.Index+1, res.Origin, rule)
+		if res.BalancerTag != "" {
+			fmt.Printf("balancer: %s\n", res.BalancerTag)
+		} else {
+			fmt.Printf("outbound: %s\n", res.OutboundTag)
+		}
+	}
+	if len(res.Unevaluated) > 0 {
+		idx := make([]string, len(res.Unevaluated))
+		for i, n := range res.Unevaluated {
+			idx[i] = fmt.Sprintf("#%d", n+1)
+		}
+		fmt.Printf("note:     skipped rules needing runtime context: %s\n", strings.Join(idx, ", "))
+	}
+	if res.NeedsResolve {
+		fmt.Println("note:     xray would resolve the domain and retry ip rules; run route again with the resolved ip")
+	}
+	return nil
+}
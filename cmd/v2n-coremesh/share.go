@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/state"
+	"github.com/urfave/cli/v2"
+)
+
+func runShare(c *cli.Context) error {
+	confDir := strings.TrimSpace(c.String("conf-dir"))
+	stateFile, err := state.Load(confDir)
+	if err != nil {
+		return err
+	}
+	xrayConfig := sessionXrayConfig(confDir, stateFile.Config.App.GeneratedXrayConfig)
+	return printShareLinks(os.Stdout, xrayConfig, strings.ToLower(strings.TrimSpace(c.String("qr"))))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
+	"github.com/urfave/cli/v2"
+)
+
+func runSysproxyRestore(c *cli.Context) error {
+	confDir := strings.TrimSpace(c.String("conf-dir"))
+	restored, err := sysproxy.RestoreJournal(confDir, c.Bool("force"))
+	if err != nil {
+		return err
+	}
+	if !restored {
+		fmt.Println("no leftover system proxy journal")
+		return nil
+	}
+	fmt.Println("system proxy settings restored")
+	return nil
+}
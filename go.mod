@@ -7,6 +7,7 @@ require (
 	golang.org/x/sys v0.22.0
 	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.34.5
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -1,44 +1,335 @@
 package assets
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
 const (
 	geoMaxAge       = 30 * 24 * time.Hour
 	geoURLTemplate  = "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/%s.dat"
 	downloadTimeout = 90 * time.Second
+	geoMetaFileName = "geo.meta.json"
 )
 
 var geoFileNames = []string{"geosite", "geoip"}
 
-var downloadGeoFile = defaultDownloadGeoFile
+var (
+	downloadGeoFile = defaultDownloadGeoFile
+	fetchChecksum   = defaultFetchChecksum
+)
+
+// errNotModified is returned by a conditional download answered with 304.
+var errNotModified = errors.New("not modified")
+
+// geoMeta is what we remember about the last good download of one asset.
+type geoMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// defaultAssets returns the built-in geosite.dat and geoip.dat sources.
+func defaultAssets() []config.Asset {
+	out := make([]config.Asset, 0, len(geoFileNames))
+	for _, name := range geoFileNames {
+		url := fmt.Sprintf(geoURLTemplate, name)
+		out = append(out, config.Asset{
+			Name:        name,
+			URLs:        []string{url},
+			ChecksumURL: url + ".sha256sum",
+		})
+	}
+	return out
+}
+
+// mergeAssets overlays configured assets on the defaults: the non-empty
+// fields of an entry with the name of a default override that default, other
+// entries are appended.
+func mergeAssets(configured []config.Asset) []config.Asset {
+	out := defaultAssets()
+	for _, a := range configured {
+		merged := false
+		for i := range out {
+			if out[i].Name == a.Name {
+				overlayAsset(&out[i], a)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func overlayAsset(dst *config.Asset, a config.Asset) {
+	if a.File != "" {
+		dst.File = a.File
+	}
+	if len(a.URLs) > 0 {
+		dst.URLs = a.URLs
+	}
+	if a.ChecksumURL != "" {
+		dst.ChecksumURL = a.ChecksumURL
+	}
+	if a.MaxAge != "" {
+		dst.MaxAge = a.MaxAge
+	}
+	dst.Optional = dst.Optional || a.Optional
+}
+
+// asset is a config.Asset with defaults applied.
+type asset struct {
+	config.Asset
+	file   string
+	maxAge time.Duration
+}
+
+func resolveAsset(a config.Asset) (asset, error) {
+	name := strings.TrimSpace(a.Name)
+	if name == "" {
+		return asset{}, fmt.Errorf("asset name is required")
+	}
+	if len(a.URLs) == 0 {
+		return asset{}, fmt.Errorf("asset %s: at least one url is required", name)
+	}
+	file := strings.TrimSpace(a.File)
+	if file == "" {
+		file = name + ".dat"
+	}
+	if filepath.Base(file) != file {
+		return asset{}, fmt.Errorf("asset %s: file must be a plain file name: %q", name, file)
+	}
+	maxAge := geoMaxAge
+	if v := strings.TrimSpace(a.MaxAge); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return asset{}, fmt.Errorf("asset %s: invalid max_age %q", name, v)
+		}
+		maxAge = d
+	}
+	a.Name = name
+	return asset{Asset: a, file: file, maxAge: maxAge}, nil
+}
+
+// Options controls an EnsureGeoFiles pass.
+type Options struct {
+	// Assets comes from assets.yaml and is merged over the default
+	// geosite/geoip sources.
+	Assets []config.Asset
+	Logf   func(format string, args ...any)
+	// Offline disables downloads; stale files are accepted with a warning.
+	Offline bool
+	// FallbackDir is searched for a missing asset that cannot be downloaded,
+	// e.g. the v2rayN bin directory.
+	FallbackDir string
+	// Proxy routes downloads through a proxy such as xray's local inbound.
+	Proxy *url.URL
+}
 
-func EnsureGeoFiles(confDir string, now time.Time) error {
+// Report summarizes an EnsureGeoFiles pass.
+type Report struct {
+	// Updated lists assets replaced by a verified download.
+	Updated []string
+	// Stale lists assets that are still missing or older than their max_age.
+	Stale []string
+}
+
+// EnsureGeoFiles downloads missing or stale assets into confDir, trying the
+// mirrors of each asset in order. A download that fails, does not match its
+// checksum or does not parse as a geo list never replaces an existing file:
+// the old file is kept and the failure is reported through Logf. An error is
+// returned only when a required asset is missing and neither a mirror nor
+// FallbackDir could provide it.
+func EnsureGeoFiles(confDir string, now time.Time, opts Options) (Report, error) {
+	var report Report
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	list := mergeAssets(opts.Assets)
+	resolved := make([]asset, 0, len(list))
+	for _, a := range list {
+		ra, err := resolveAsset(a)
+		if err != nil {
+			return report, err
+		}
+		resolved = append(resolved, ra)
+	}
 	if err := os.MkdirAll(confDir, 0o755); err != nil {
-		return fmt.Errorf("create conf dir: %w", err)
+		return report, fmt.Errorf("create conf dir: %w", err)
 	}
+	meta := loadGeoMeta(confDir)
+	client := newHTTPClient(opts.Proxy)
 
-	for _, name := range geoFileNames {
-		target := filepath.Join(confDir, name+".dat")
-		need, err := needsRefresh(target, now, geoMaxAge)
+	for _, a := range resolved {
+		target := filepath.Join(confDir, a.file)
+		need, err := needsRefresh(target, now, a.maxAge)
 		if err != nil {
-			return fmt.Errorf("check %s: %w", target, err)
+			return report, fmt.Errorf("check %s: %w", target, err)
 		}
 		if !need {
 			continue
 		}
-		url := fmt.Sprintf(geoURLTemplate, name)
-		if err := downloadGeoFile(url, target); err != nil {
-			return fmt.Errorf("download %s: %w", name, err)
+		_, statErr := os.Stat(target)
+		exists := statErr == nil
+		if opts.Offline {
+			if exists {
+				logf("offline: using stale asset %s", a.Name)
+				report.Stale = append(report.Stale, a.Name)
+				continue
+			}
+			err = errors.New("offline mode")
+		} else {
+			prev := meta[a.Name]
+			if !exists {
+				prev = geoMeta{}
+			} else if prev.SHA256 == "" {
+				prev.SHA256, _ = fileSHA256(target)
+			}
+			var next geoMeta
+			next, err = refreshAsset(client, a, target, prev)
+			if err == nil {
+				meta[a.Name] = next
+				if err := saveGeoMeta(confDir, meta); err != nil {
+					return report, err
+				}
+				// Servers without validators resend identical content.
+				if exists && next.SHA256 == prev.SHA256 {
+					if err := os.Chtimes(target, now, now); err != nil {
+						return report, fmt.Errorf("touch %s: %w", target, err)
+					}
+					logf("asset %s unchanged", a.Name)
+					continue
+				}
+				logf("asset %s updated: sha256 %s -> %s", a.Name, shortSum(prev.SHA256), shortSum(next.SHA256))
+				report.Updated = append(report.Updated, a.Name)
+				continue
+			}
+			if errors.Is(err, errNotModified) {
+				if err := os.Chtimes(target, now, now); err != nil {
+					return report, fmt.Errorf("touch %s: %w", target, err)
+				}
+				logf("asset %s not modified", a.Name)
+				continue
+			}
 		}
+		report.Stale = append(report.Stale, a.Name)
+		if exists {
+			logf("refresh %s failed, keeping previous file: %v", a.Name, err)
+			continue
+		}
+		if opts.FallbackDir != "" {
+			src := filepath.Join(opts.FallbackDir, a.file)
+			copyErr := copyAssetFile(src, target)
+			if copyErr == nil {
+				logf("asset %s unavailable (%v), copied from %s", a.Name, err, src)
+				continue
+			}
+			if !errors.Is(copyErr, os.ErrNotExist) {
+				logf("copy %s from %s failed: %v", a.Name, src, copyErr)
+			}
+		}
+		if a.Optional {
+			logf("optional asset %s unavailable: %v", a.Name, err)
+			continue
+		}
+		return report, fmt.Errorf("download %s: %w", a.Name, err)
 	}
-	return nil
+	return report, nil
+}
+
+// refreshAsset tries each mirror in turn and returns the first success.
+func refreshAsset(client *http.Client, a asset, target string, prev geoMeta) (geoMeta, error) {
+	var errs []error
+	for _, url := range a.URLs {
+		next, err := refreshGeoFile(client, url, a.ChecksumURL, target, prev)
+		if err == nil || errors.Is(err, errNotModified) {
+			return next, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return geoMeta{}, errors.Join(errs...)
+}
+
+// copyAssetFile copies a verified geo list from src to target, keeping the
+// source mtime so an old copy still counts as stale.
+func copyAssetFile(src, target string) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := VerifyGeoList(src); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, st.ModTime(), st.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func newHTTPClient(proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: downloadTimeout, Transport: transport}
+}
+
+// refreshGeoFile downloads url next to target, verifies it against
+// checksumURL (when set) and only then renames it over target.
+func refreshGeoFile(client *http.Client, url, checksumURL, target string, prev geoMeta) (geoMeta, error) {
+	tmp := target + ".tmp"
+	defer os.Remove(tmp)
+
+	next, err := downloadGeoFile(client, url, tmp, prev)
+	if err != nil {
+		return geoMeta{}, err
+	}
+	sum, err := fileSHA256(tmp)
+	if err != nil {
+		return geoMeta{}, err
+	}
+	if checksumURL != "" {
+		want, err := fetchChecksum(client, checksumURL)
+		if err != nil {
+			return geoMeta{}, fmt.Errorf("fetch checksum: %w", err)
+		}
+		if !strings.EqualFold(sum, want) {
+			return geoMeta{}, fmt.Errorf("checksum mismatch: got %s, published %s", sum, want)
+		}
+	}
+	if err := VerifyGeoList(tmp); err != nil {
+		return geoMeta{}, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return geoMeta{}, err
+	}
+	next.SHA256 = sum
+	return next, nil
 }
 
 func needsRefresh(path string, now time.Time, maxAge time.Duration) (bool, error) {
@@ -55,29 +346,133 @@ func needsRefresh(path string, now time.Time, maxAge time.Duration) (bool, error
 	return now.Sub(st.ModTime()) > maxAge, nil
 }
 
-func defaultDownloadGeoFile(url, target string) error {
-	client := &http.Client{Timeout: downloadTimeout}
-	resp, err := client.Get(url)
+// defaultDownloadGeoFile performs a conditional GET using the validators in
+// prev and writes a 2xx body to target. It returns errNotModified on 304.
+func defaultDownloadGeoFile(client *http.Client, url, target string, prev geoMeta) (geoMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return geoMeta{}, err
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return geoMeta{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return geoMeta{}, errNotModified
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		return geoMeta{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
 
-	tmp := target + ".tmp"
-	f, err := os.Create(tmp)
+	f, err := os.Create(target)
 	if err != nil {
-		return err
+		return geoMeta{}, err
 	}
-	_, copyErr := io.Copy(f, resp.Body)
+	n, copyErr := io.Copy(f, resp.Body)
 	closeErr := f.Close()
 	if copyErr != nil {
-		return copyErr
+		return geoMeta{}, copyErr
 	}
 	if closeErr != nil {
-		return closeErr
+		return geoMeta{}, closeErr
 	}
-	return os.Rename(tmp, target)
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return geoMeta{}, fmt.Errorf("truncated body: got %d of %d bytes", n, resp.ContentLength)
+	}
+	return geoMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// defaultFetchChecksum reads a sha256sum file ("<hex>  <name>") and returns
+// the hex digest.
+func defaultFetchChecksum(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	return parseChecksum(string(b))
+}
+
+func parseChecksum(s string) (string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file")
+	}
+	sum := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 checksum %q", fields[0])
+	}
+	return sum, nil
+}
+
+// shortSum abbreviates a sha256 digest for logs.
+func shortSum(sum string) string {
+	switch {
+	case sum == "":
+		return "none"
+	case len(sum) > 12:
+		return sum[:12]
+	default:
+		return sum
+	}
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadGeoMeta reads geo.meta.json. A missing or unreadable file yields an
+// empty map, which only costs an unconditional download.
+func loadGeoMeta(confDir string) map[string]geoMeta {
+	meta := map[string]geoMeta{}
+	b, err := os.ReadFile(filepath.Join(confDir, geoMetaFileName))
+	if err != nil {
+		return meta
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return map[string]geoMeta{}
+	}
+	return meta
+}
+
+func saveGeoMeta(confDir string, meta map[string]geoMeta) error {
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal geo metadata: %w", err)
+	}
+	target := filepath.Join(confDir, geoMetaFileName)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write geo metadata: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("rename geo metadata: %w", err)
+	}
+	return nil
 }
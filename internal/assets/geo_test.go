@@ -1,10 +1,21 @@
 package assets
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets/geotest"
+	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
 func TestNeedsRefresh(t *testing.T) {
@@ -54,27 +65,323 @@ func TestNeedsRefresh(t *testing.T) {
 func TestEnsureGeoFilesTriggersDownload(t *testing.T) {
 	now := time.Now()
 	tmp := t.TempDir()
+	payload := validGeoPayload()
 
 	var downloaded []string
-	oldDownloader := downloadGeoFile
-	downloadGeoFile = func(url, target string) error {
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
 		downloaded = append(downloaded, filepath.Base(target))
-		return os.WriteFile(target, []byte(url), 0o644)
-	}
-	defer func() { downloadGeoFile = oldDownloader }()
+		return geoMeta{ETag: `"v1"`}, os.WriteFile(target, payload, 0o644)
+	}, sha256Hex(payload))
 
-	if err := EnsureGeoFiles(tmp, now); err != nil {
+	if _, err := EnsureGeoFiles(tmp, now, Options{}); err != nil {
 		t.Fatalf("ensure missing files: %v", err)
 	}
 	if len(downloaded) != 2 {
 		t.Fatalf("unexpected downloaded files: %#v", downloaded)
 	}
+	meta := loadGeoMeta(tmp)
+	if meta["geosite"].ETag != `"v1"` || meta["geosite"].SHA256 != sha256Hex(payload) {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
 
 	downloaded = downloaded[:0]
-	if err := EnsureGeoFiles(tmp, now.Add(5*24*time.Hour)); err != nil {
+	if _, err := EnsureGeoFiles(tmp, now.Add(5*24*time.Hour), Options{}); err != nil {
 		t.Fatalf("ensure fresh files: %v", err)
 	}
 	if len(downloaded) != 0 {
 		t.Fatalf("fresh files should not be downloaded: %#v", downloaded)
 	}
 }
+
+func TestEnsureGeoFilesIgnoresUnchangedDownload(t *testing.T) {
+	now := time.Now()
+	tmp := t.TempDir()
+	payload := validGeoPayload()
+	for _, name := range geoFileNames {
+		path := filepath.Join(tmp, name+".dat")
+		if err := os.WriteFile(path, payload, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now, now.Add(-40*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+		return geoMeta{}, os.WriteFile(target, payload, 0o644)
+	}, sha256Hex(payload))
+
+	report, err := EnsureGeoFiles(tmp, now, Options{})
+	if err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if len(report.Updated) != 0 || len(report.Stale) != 0 {
+		t.Fatalf("identical content should not be reported: %#v", report)
+	}
+	st, err := os.Stat(filepath.Join(tmp, "geosite.dat"))
+	if err != nil || now.Sub(st.ModTime()).Abs() > time.Second {
+		t.Fatalf("unchanged file should be touched: %v", err)
+	}
+}
+
+func TestEnsureGeoFilesKeepsPreviousFile(t *testing.T) {
+	now := time.Now()
+	tmp := t.TempDir()
+	good := validGeoPayload()
+	for _, name := range geoFileNames {
+		path := filepath.Join(tmp, name+".dat")
+		if err := os.WriteFile(path, good, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now, now.Add(-40*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		body     []byte
+		checksum string
+	}{
+		{"checksum mismatch", validGeoPayload(), strings.Repeat("0", 64)},
+		{"html error page", []byte("<html>rate limited</html>"), sha256Hex([]byte("<html>rate limited</html>"))},
+	}
+	for _, tc := range cases {
+		stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+			return geoMeta{}, os.WriteFile(target, tc.body, 0o644)
+		}, tc.checksum)
+
+		var warnings []string
+		logf := func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+		if _, err := EnsureGeoFiles(tmp, now, Options{Logf: logf}); err != nil {
+			t.Fatalf("%s: stale file should be kept without error: %v", tc.name, err)
+		}
+		if len(warnings) != 2 || !strings.Contains(warnings[0], "keeping previous file") {
+			t.Fatalf("%s: unexpected warnings: %#v", tc.name, warnings)
+		}
+		b, err := os.ReadFile(filepath.Join(tmp, "geosite.dat"))
+		if err != nil || string(b) != string(good) {
+			t.Fatalf("%s: previous file was replaced", tc.name)
+		}
+		if _, err := os.Stat(filepath.Join(tmp, "geosite.dat.tmp")); !os.IsNotExist(err) {
+			t.Fatalf("%s: temp file left behind", tc.name)
+		}
+	}
+
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+		return geoMeta{}, fmt.Errorf("connection refused")
+	}, "")
+	if _, err := EnsureGeoFiles(t.TempDir(), now, Options{}); err == nil {
+		t.Fatal("missing file with failed download should be an error")
+	}
+}
+
+func TestMergeAssetsKeepsDefaultsOfPartialOverride(t *testing.T) {
+	merged := mergeAssets([]config.Asset{
+		{Name: "geoip", MaxAge: "24h"},
+		{Name: "geosite", ChecksumURL: "https://mirror.example/geosite.dat.sha256sum"},
+	})
+	defaults := defaultAssets()
+	if len(merged) != 2 {
+		t.Fatalf("unexpected assets: %#v", merged)
+	}
+	site, ip := merged[0], merged[1]
+	if ip.MaxAge != "24h" || len(ip.URLs) != 1 || ip.URLs[0] != defaults[1].URLs[0] || ip.ChecksumURL != defaults[1].ChecksumURL {
+		t.Fatalf("geoip override dropped defaults: %#v", ip)
+	}
+	if site.ChecksumURL != "https://mirror.example/geosite.dat.sha256sum" || site.URLs[0] != defaults[0].URLs[0] {
+		t.Fatalf("unexpected geosite: %#v", site)
+	}
+	if _, err := resolveAsset(ip); err != nil {
+		t.Fatalf("merged asset should resolve: %v", err)
+	}
+}
+
+func TestEnsureGeoFilesUsesConfiguredMirrors(t *testing.T) {
+	tmp := t.TempDir()
+	payload := validGeoPayload()
+
+	var tried []string
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+		tried = append(tried, url)
+		if strings.Contains(url, "broken") {
+			return geoMeta{}, fmt.Errorf("unexpected status 503")
+		}
+		return geoMeta{}, os.WriteFile(target, payload, 0o644)
+	}, sha256Hex(payload))
+
+	configured := []config.Asset{
+		{Name: "geosite", URLs: []string{"https://broken.example/geosite.dat", "https://mirror.example/geosite.dat"}},
+		{Name: "company", URLs: []string{"https://intranet.example/company.dat"}, MaxAge: "24h"},
+		{Name: "extra", File: "geosite-ir.dat", URLs: []string{"https://broken.example/ir.dat"}, Optional: true},
+	}
+	if _, err := EnsureGeoFiles(tmp, time.Now(), Options{Assets: configured}); err != nil {
+		t.Fatalf("ensure configured assets: %v", err)
+	}
+	want := []string{
+		"https://broken.example/geosite.dat",
+		"https://mirror.example/geosite.dat",
+		fmt.Sprintf(geoURLTemplate, "geoip"),
+		"https://intranet.example/company.dat",
+		"https://broken.example/ir.dat",
+	}
+	if strings.Join(tried, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected download order:\n%s", strings.Join(tried, "\n"))
+	}
+	for _, name := range []string{"geosite.dat", "company.dat"} {
+		if _, err := os.Stat(filepath.Join(tmp, name)); err != nil {
+			t.Fatalf("%s not downloaded: %v", name, err)
+		}
+	}
+
+	configured[2].Optional = false
+	if _, err := EnsureGeoFiles(tmp, time.Now(), Options{Assets: configured}); err == nil {
+		t.Fatal("missing required asset should be an error")
+	}
+	if _, err := EnsureGeoFiles(tmp, time.Now(), Options{Assets: []config.Asset{{Name: "x"}}}); err == nil {
+		t.Fatal("asset without urls should be rejected")
+	}
+}
+
+func TestEnsureGeoFilesOfflineAndFallback(t *testing.T) {
+	now := time.Now()
+	payload := validGeoPayload()
+	fallback := t.TempDir()
+	for _, name := range geoFileNames {
+		path := filepath.Join(fallback, name+".dat")
+		if err := os.WriteFile(path, payload, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now, now.Add(-60*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+		t.Fatalf("offline mode must not download %s", url)
+		return geoMeta{}, nil
+	}, "")
+
+	if _, err := EnsureGeoFiles(t.TempDir(), now, Options{Offline: true}); err == nil {
+		t.Fatal("offline without files or fallback should fail")
+	}
+
+	tmp := t.TempDir()
+	report, err := EnsureGeoFiles(tmp, now, Options{Offline: true, FallbackDir: fallback})
+	if err != nil {
+		t.Fatalf("offline with fallback: %v", err)
+	}
+	if len(report.Stale) != 2 || len(report.Updated) != 0 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	st, err := os.Stat(filepath.Join(tmp, "geosite.dat"))
+	if err != nil {
+		t.Fatalf("fallback copy missing: %v", err)
+	}
+	if now.Sub(st.ModTime()) < 59*24*time.Hour {
+		t.Fatal("fallback copy should keep the source mtime")
+	}
+
+	var warnings []string
+	logf := func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+	report, err = EnsureGeoFiles(tmp, now, Options{Offline: true, Logf: logf})
+	if err != nil || len(report.Stale) != 2 {
+		t.Fatalf("offline with stale files: %#v %v", report, err)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "stale") {
+		t.Fatalf("expected stale warnings: %#v", warnings)
+	}
+
+	stubGeoDownload(t, func(url, target string, prev geoMeta) (geoMeta, error) {
+		return geoMeta{}, fmt.Errorf("dial tcp: i/o timeout")
+	}, "")
+	tmp = t.TempDir()
+	report, err = EnsureGeoFiles(tmp, now, Options{FallbackDir: fallback})
+	if err != nil || len(report.Stale) != 2 {
+		t.Fatalf("failed download should fall back: %#v %v", report, err)
+	}
+}
+
+func TestEnsureGeoFilesUsesProxy(t *testing.T) {
+	payload := validGeoPayload()
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		if strings.HasSuffix(r.URL.Path, ".sha256sum") {
+			fmt.Fprintf(w, "%s  geo.dat\n", sha256Hex(payload))
+			return
+		}
+		w.Write(payload)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configured := []config.Asset{
+		{Name: "geosite", URLs: []string{"http://geo.invalid/geosite.dat"}, ChecksumURL: "http://geo.invalid/geosite.dat.sha256sum"},
+		{Name: "geoip", URLs: []string{"http://geo.invalid/geoip.dat"}, ChecksumURL: "http://geo.invalid/geoip.dat.sha256sum"},
+	}
+	report, err := EnsureGeoFiles(t.TempDir(), time.Now(), Options{Assets: configured, Proxy: proxyURL})
+	if err != nil {
+		t.Fatalf("ensure through proxy: %v", err)
+	}
+	if len(report.Updated) != 2 || len(hosts) != 4 || hosts[0] != "geo.invalid" {
+		t.Fatalf("unexpected proxy use: %#v %#v", report, hosts)
+	}
+}
+
+func TestDefaultDownloadGeoFileConditional(t *testing.T) {
+	payload := validGeoPayload()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "geosite.dat")
+	meta, err := defaultDownloadGeoFile(srv.Client(), srv.URL, target, geoMeta{})
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if meta.ETag != `"v1"` || meta.LastModified == "" {
+		t.Fatalf("unexpected validators: %#v", meta)
+	}
+	if _, err := defaultDownloadGeoFile(srv.Client(), srv.URL, target, meta); !errors.Is(err, errNotModified) {
+		t.Fatalf("expected errNotModified, got %v", err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	got, err := parseChecksum(strings.ToUpper(sum) + "  geosite.dat\n")
+	if err != nil || got != sum {
+		t.Fatalf("parse checksum: %q %v", got, err)
+	}
+	if _, err := parseChecksum("<html>"); err == nil {
+		t.Fatal("expected invalid checksum error")
+	}
+}
+
+func validGeoPayload() []byte {
+	return geotest.SiteList(geotest.Site{Code: "CN"})
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func stubGeoDownload(t *testing.T, download func(url, target string, prev geoMeta) (geoMeta, error), checksum string) {
+	t.Helper()
+	oldDownload, oldChecksum := downloadGeoFile, fetchChecksum
+	downloadGeoFile = func(_ *http.Client, url, target string, prev geoMeta) (geoMeta, error) {
+		return download(url, target, prev)
+	}
+	fetchChecksum = func(*http.Client, string) (string, error) { return checksum, nil }
+	t.Cleanup(func() { downloadGeoFile, fetchChecksum = oldDownload, oldChecksum })
+}
@@ -0,0 +1,345 @@
+package assets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DomainType mirrors router.Domain.Type in v2ray/xray geosite.dat files.
+type DomainType int
+
+const (
+	DomainPlain DomainType = iota // keyword match
+	DomainRegex
+	DomainRoot // the domain and its subdomains
+	DomainFull
+)
+
+// GeoDomain is one domain entry of a geosite category.
+type GeoDomain struct {
+	Type  DomainType
+	Value string
+	Attrs []string
+}
+
+// HasAttrs reports whether the entry carries every attribute in attrs.
+func (d GeoDomain) HasAttrs(attrs []string) bool {
+	for _, want := range attrs {
+		found := false
+		for _, have := range d.Attrs {
+			if strings.EqualFold(have, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// GeoIP is one geoip category.
+type GeoIP struct {
+	Code         string
+	CIDRs        []netip.Prefix
+	ReverseMatch bool
+}
+
+// ErrGeoCodeNotFound is returned when a category is missing from a .dat file.
+var ErrGeoCodeNotFound = errors.New("geo code not found")
+
+// GeoSiteCategory summarizes one geosite category.
+type GeoSiteCategory struct {
+	Code  string
+	Attrs []string
+}
+
+// ListGeoSite returns every category of a geosite.dat file together with the
+// attributes (as in "geosite:cn@ads") used by its domains.
+func ListGeoSite(path string) ([]GeoSiteCategory, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var out []GeoSiteCategory
+	err = walkProto(b, func(num int, _ uint64, entry []byte) error {
+		if num != 1 {
+			return nil
+		}
+		cat := GeoSiteCategory{}
+		attrs := map[string]struct{}{}
+		err := walkProto(entry, func(num int, _ uint64, data []byte) error {
+			switch num {
+			case 1:
+				cat.Code = string(data)
+			case 2:
+				d, err := decodeGeoDomain(data)
+				if err != nil {
+					return err
+				}
+				for _, a := range d.Attrs {
+					attrs[strings.ToLower(a)] = struct{}{}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for a := range attrs {
+			cat.Attrs = append(cat.Attrs, a)
+		}
+		sort.Strings(cat.Attrs)
+		out = append(out, cat)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// ListGeoIP returns the category codes of a geoip.dat file.
+func ListGeoIP(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var out []string
+	err = walkProto(b, func(num int, _ uint64, entry []byte) error {
+		if num != 1 {
+			return nil
+		}
+		code, err := geoEntryCode(entry)
+		if err != nil {
+			return err
+		}
+		out = append(out, code)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// VerifyGeoList checks that path holds a non-empty GeoSiteList or GeoIPList
+// whose entries all carry a category code.
+func VerifyGeoList(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	entries := 0
+	err = walkProto(b, func(num int, _ uint64, entry []byte) error {
+		if num != 1 {
+			return fmt.Errorf("unexpected field %d", num)
+		}
+		code, err := geoEntryCode(entry)
+		if err != nil {
+			return err
+		}
+		if code == "" {
+			return fmt.Errorf("entry %d has no code", entries)
+		}
+		entries++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s is not a geo list: %w", path, err)
+	}
+	if entries == 0 {
+		return fmt.Errorf("%s is not a geo list: no entries", path)
+	}
+	return nil
+}
+
+// ReadGeoSite decodes the domains of one category from a geosite.dat file.
+func ReadGeoSite(path, code string) ([]GeoDomain, error) {
+	entry, err := findGeoEntry(path, code)
+	if err != nil {
+		return nil, err
+	}
+	var domains []GeoDomain
+	err = walkProto(entry, func(num int, _ uint64, data []byte) error {
+		if num != 2 {
+			return nil
+		}
+		d, err := decodeGeoDomain(data)
+		if err != nil {
+			return err
+		}
+		domains = append(domains, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode geosite %s from %s: %w", code, path, err)
+	}
+	return domains, nil
+}
+
+// ReadGeoIP decodes one category from a geoip.dat file.
+func ReadGeoIP(path, code string) (*GeoIP, error) {
+	entry, err := findGeoEntry(path, code)
+	if err != nil {
+		return nil, err
+	}
+	geo := &GeoIP{Code: strings.ToUpper(code)}
+	err = walkProto(entry, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 2:
+			prefix, err := decodeCIDR(data)
+			if err != nil {
+				return err
+			}
+			geo.CIDRs = append(geo.CIDRs, prefix)
+		case 3:
+			geo.ReverseMatch = v != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode geoip %s from %s: %w", code, path, err)
+	}
+	return geo, nil
+}
+
+// findGeoEntry returns the raw GeoSite/GeoIP message whose country_code
+// equals code. Both list formats share the same outer layout.
+func findGeoEntry(path, code string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var found []byte
+	err = walkProto(b, func(num int, _ uint64, entry []byte) error {
+		if num != 1 || found != nil {
+			return nil
+		}
+		entryCode, err := geoEntryCode(entry)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(entryCode, code) {
+			found = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: %q: %w", path, code, ErrGeoCodeNotFound)
+	}
+	return found, nil
+}
+
+func geoEntryCode(entry []byte) (string, error) {
+	var code string
+	err := walkProto(entry, func(num int, _ uint64, data []byte) error {
+		if num == 1 {
+			code = string(data)
+		}
+		return nil
+	})
+	return code, err
+}
+
+func decodeGeoDomain(b []byte) (GeoDomain, error) {
+	var d GeoDomain
+	err := walkProto(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			d.Type = DomainType(v)
+		case 2:
+			d.Value = string(data)
+		case 3:
+			key, err := geoEntryCode(data)
+			if err != nil {
+				return err
+			}
+			d.Attrs = append(d.Attrs, key)
+		}
+		return nil
+	})
+	return d, err
+}
+
+func decodeCIDR(b []byte) (netip.Prefix, error) {
+	var ip []byte
+	var bits uint64
+	err := walkProto(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			ip = data
+		case 2:
+			bits = v
+		}
+		return nil
+	})
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr ip length %d", len(ip))
+	}
+	prefix := netip.PrefixFrom(addr, int(bits))
+	if !prefix.IsValid() {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr prefix %s/%d", addr, bits)
+	}
+	return prefix, nil
+}
+
+// walkProto iterates over the top-level fields of a protobuf message. For
+// varint fields v holds the value; for length-delimited fields data holds
+// the payload. Fixed-width fields are skipped.
+func walkProto(b []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("malformed field key")
+		}
+		b = b[n:]
+		num, wire := int(key>>3), key&7
+		var v uint64
+		var data []byte
+		switch wire {
+		case 0:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", num)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated fixed64 in field %d", num)
+			}
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("truncated bytes in field %d", num)
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case 5:
+			if len(b) < 4 {
+				return fmt.Errorf("truncated fixed32 in field %d", num)
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wire, num)
+		}
+		if err := fn(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
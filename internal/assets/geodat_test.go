@@ -0,0 +1,94 @@
+package assets
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets/geotest"
+)
+
+func writeTestGeoFiles(t *testing.T, dir string) {
+	t.Helper()
+	geotest.WriteSites(t, filepath.Join(dir, "geosite.dat"),
+		geotest.Site{Code: "EXAMPLE", Domains: []geotest.Domain{
+			{Type: geotest.Root, Value: "example.com"},
+			{Type: geotest.Full, Value: "ads.example.net", Attrs: []string{"ads"}},
+		}},
+		geotest.Site{Code: "OTHER"},
+	)
+	geotest.WriteIPs(t, filepath.Join(dir, "geoip.dat"), geotest.IP{Code: "PRIVATE", CIDRs: []string{"10.0.0.0/8", "fd00::/8"}})
+}
+
+func TestReadGeoSite(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGeoFiles(t, dir)
+
+	domains, err := ReadGeoSite(filepath.Join(dir, "geosite.dat"), "example")
+	if err != nil {
+		t.Fatalf("read geosite: %v", err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("unexpected domains: %#v", domains)
+	}
+	if domains[0].Type != DomainRoot || domains[0].Value != "example.com" {
+		t.Fatalf("unexpected first domain: %#v", domains[0])
+	}
+	if !domains[1].HasAttrs([]string{"ads"}) || domains[0].HasAttrs([]string{"ads"}) {
+		t.Fatalf("unexpected attrs: %#v", domains)
+	}
+
+	_, err = ReadGeoSite(filepath.Join(dir, "geosite.dat"), "missing")
+	if !errors.Is(err, ErrGeoCodeNotFound) {
+		t.Fatalf("expected ErrGeoCodeNotFound, got %v", err)
+	}
+}
+
+func TestReadGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGeoFiles(t, dir)
+
+	geo, err := ReadGeoIP(filepath.Join(dir, "geoip.dat"), "private")
+	if err != nil {
+		t.Fatalf("read geoip: %v", err)
+	}
+	if len(geo.CIDRs) != 2 || geo.CIDRs[0] != netip.MustParsePrefix("10.0.0.0/8") || geo.CIDRs[1] != netip.MustParsePrefix("fd00::/8") {
+		t.Fatalf("unexpected cidrs: %#v", geo.CIDRs)
+	}
+}
+
+func TestReadGeoSiteRejectsTruncatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geosite.dat")
+	if err := os.WriteFile(path, []byte{0x0a, 0x20, 0x01}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGeoSite(path, "cn"); err == nil {
+		t.Fatal("expected error for truncated file")
+	}
+}
+
+func TestListGeoCategories(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGeoFiles(t, dir)
+
+	sites, err := ListGeoSite(filepath.Join(dir, "geosite.dat"))
+	if err != nil {
+		t.Fatalf("list geosite: %v", err)
+	}
+	if len(sites) != 2 || sites[0].Code != "EXAMPLE" || len(sites[0].Attrs) != 1 || sites[0].Attrs[0] != "ads" {
+		t.Fatalf("unexpected geosite categories: %#v", sites)
+	}
+	if sites[1].Code != "OTHER" || len(sites[1].Attrs) != 0 {
+		t.Fatalf("unexpected second category: %#v", sites[1])
+	}
+
+	ips, err := ListGeoIP(filepath.Join(dir, "geoip.dat"))
+	if err != nil {
+		t.Fatalf("list geoip: %v", err)
+	}
+	if len(ips) != 1 || ips[0] != "PRIVATE" {
+		t.Fatalf("unexpected geoip codes: %#v", ips)
+	}
+}
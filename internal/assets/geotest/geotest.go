@@ -0,0 +1,95 @@
+// Package geotest writes small geosite.dat and geoip.dat files for tests.
+package geotest
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+// Domain types, as in the v2ray GeoSite format.
+const (
+	Plain = iota // keyword match
+	Regex
+	Root // domain and its subdomains
+	Full
+)
+
+// Domain is one GeoSite rule; Attrs are its @attribute names.
+type Domain struct {
+	Type  int
+	Value string
+	Attrs []string
+}
+
+// Site is one GeoSite category.
+type Site struct {
+	Code    string
+	Domains []Domain
+}
+
+// IP is one GeoIP category; CIDRs are parsed with netip.MustParsePrefix.
+type IP struct {
+	Code  string
+	CIDRs []string
+}
+
+// SiteList encodes a GeoSiteList.
+func SiteList(sites ...Site) []byte {
+	var out []byte
+	for _, s := range sites {
+		entry := bytesField(1, []byte(s.Code))
+		for _, d := range s.Domains {
+			domain := append(varintField(1, uint64(d.Type)), bytesField(2, []byte(d.Value))...)
+			for _, a := range d.Attrs {
+				domain = append(domain, bytesField(3, bytesField(1, []byte(a)))...)
+			}
+			entry = append(entry, bytesField(2, domain)...)
+		}
+		out = append(out, bytesField(1, entry)...)
+	}
+	return out
+}
+
+// IPList encodes a GeoIPList.
+func IPList(ips ...IP) []byte {
+	var out []byte
+	for _, ip := range ips {
+		entry := bytesField(1, []byte(ip.Code))
+		for _, c := range ip.CIDRs {
+			p := netip.MustParsePrefix(c)
+			cidr := append(bytesField(1, p.Addr().AsSlice()), varintField(2, uint64(p.Bits()))...)
+			entry = append(entry, bytesField(2, cidr)...)
+		}
+		out = append(out, bytesField(1, entry)...)
+	}
+	return out
+}
+
+// WriteSites writes SiteList(sites...) to path.
+func WriteSites(t testing.TB, path string, sites ...Site) {
+	t.Helper()
+	if err := os.WriteFile(path, SiteList(sites...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// WriteIPs writes IPList(ips...) to path.
+func WriteIPs(t testing.TB, path string, ips ...IP) {
+	t.Helper()
+	if err := os.WriteFile(path, IPList(ips...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bytesField encodes a length-delimited protobuf field.
+func bytesField(num int, data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func varintField(num int, v uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(num)<<3), v)
+}
@@ -0,0 +1,252 @@
+package bindmode
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CredentialsFileName holds the generated LAN inbound account.
+	CredentialsFileName = "lan_auth.json"
+	defaultLANUser      = "coremesh"
+	lanDenyOutboundTag  = "lan-deny"
+)
+
+// Credentials is the account injected into exposed socks/http/mixed inbounds.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoadOrCreateCredentials reads <confDir>/lan_auth.json, generating a random
+// account on first use. created reports whether the file was written.
+func LoadOrCreateCredentials(confDir string) (creds *Credentials, created bool, err error) {
+	path := filepath.Join(confDir, CredentialsFileName)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		var c Credentials
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, false, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if c.Username == "" || c.Password == "" {
+			return nil, false, fmt.Errorf("%s needs a username and a password", path)
+		}
+		return &c, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, false, fmt.Errorf("generate lan password: %w", err)
+	}
+	c := &Credentials{Username: defaultLANUser, Password: hex.EncodeToString(secret)}
+	b, err = json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, false, fmt.Errorf("marshal lan credentials: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return nil, false, fmt.Errorf("write %s: %w", path, err)
+	}
+	return c, true, nil
+}
+
+// ParseAllowList parses client CIDRs or single IPs.
+func ParseAllowList(items []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, item := range items {
+		for _, part := range strings.Split(item, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if !strings.Contains(part, "/") {
+				addr, err := netip.ParseAddr(part)
+				if err != nil {
+					return nil, fmt.Errorf("invalid allowed client %q: %w", part, err)
+				}
+				out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed client %q: %w", part, err)
+			}
+			out = append(out, prefix.Masked())
+		}
+	}
+	return out, nil
+}
+
+// secureInbounds adds creds as the only account of every socks, http and
+// mixed inbound scope exposes.
+func secureInbounds(doc any, creds *Credentials, scope Scope) {
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+	inbounds, _ := m["inbounds"].([]any)
+	for _, raw := range inbounds {
+		inbound, ok := raw.(map[string]any)
+		if !ok || !scope.exposes(inbound) {
+			continue
+		}
+		protocol, _ := inbound["protocol"].(string)
+		protocol = strings.ToLower(protocol)
+		if protocol != "socks" && protocol != "http" && protocol != "mixed" {
+			continue
+		}
+		settings, _ := inbound["settings"].(map[string]any)
+		if settings == nil {
+			settings = map[string]any{}
+		}
+		if protocol != "http" {
+			settings["auth"] = "password"
+		}
+		settings["accounts"] = []any{map[string]any{"user": creds.Username, "pass": creds.Password}}
+		inbound["settings"] = settings
+	}
+}
+
+// interfaceAddrs is replaced in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// restrictClients puts a rule in front of all others that sends connections
+// from clients outside allow to a blackhole. Loopback, host and the addresses
+// of local interfaces are always allowed, since local traffic to a LAN
+// listener arrives from them.
+func restrictClients(doc any, allow []netip.Prefix, host string) {
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+	allowed := append(localPrefixes(host), allow...)
+	var denied []any
+	for _, p := range denyList(allowed) {
+		denied = append(denied, p.String())
+	}
+	if len(denied) == 0 {
+		return
+	}
+
+	outbounds, _ := m["outbounds"].([]any)
+	m["outbounds"] = append(outbounds, map[string]any{"tag": lanDenyOutboundTag, "protocol": "blackhole"})
+	routing, _ := m["routing"].(map[string]any)
+	if routing == nil {
+		routing = map[string]any{}
+	}
+	rules, _ := routing["rules"].([]any)
+	rule := map[string]any{"type": "field", "source": denied, "outboundTag": lanDenyOutboundTag}
+	routing["rules"] = append([]any{rule}, rules...)
+	m["routing"] = routing
+}
+
+// localPrefixes lists the single-address prefixes of this machine.
+func localPrefixes(host string) []netip.Prefix {
+	out := []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}
+	add := func(addr netip.Addr) {
+		if addr.IsValid() && !addr.IsUnspecified() {
+			addr = addr.Unmap()
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		add(addr)
+	}
+	addrs, _ := interfaceAddrs()
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			addr, _ := netip.AddrFromSlice(ipNet.IP)
+			add(addr)
+		}
+	}
+	return out
+}
+
+// denyList returns the smallest set of prefixes covering every address
+// outside allowed, for both IPv4 and IPv6.
+func denyList(allowed []netip.Prefix) []netip.Prefix {
+	var out []netip.Prefix
+	for _, all := range []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")} {
+		out = appendComplement(out, all, allowed)
+	}
+	return out
+}
+
+func appendComplement(out []netip.Prefix, p netip.Prefix, allowed []netip.Prefix) []netip.Prefix {
+	overlaps := false
+	for _, a := range allowed {
+		if a.Addr().Is4() != p.Addr().Is4() {
+			continue
+		}
+		if a.Bits() <= p.Bits() && a.Contains(p.Addr()) {
+			return out
+		}
+		if p.Overlaps(a) {
+			overlaps = true
+		}
+	}
+	if !overlaps {
+		return append(out, p)
+	}
+	lo, hi := split(p)
+	out = appendComplement(out, lo, allowed)
+	return appendComplement(out, hi, allowed)
+}
+
+// split halves p into its two child prefixes.
+func split(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits() + 1
+	lo := netip.PrefixFrom(p.Addr(), bits)
+	b := p.Addr().AsSlice()
+	b[p.Bits()/8] |= 0x80 >> (p.Bits() % 8)
+	hiAddr, _ := netip.AddrFromSlice(b)
+	return lo, netip.PrefixFrom(hiAddr, bits)
+}
+
+// Inbound is an xray inbound as exposed by bind mode.
+type Inbound struct {
+	Tag      string
+	Protocol string
+	Listen   string
+	Port     int
+}
+
+// Inbounds lists the proxy inbounds of a runtime xray config.
+func Inbounds(xrayConfig string) ([]Inbound, error) {
+	b, err := os.ReadFile(xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read xray config: %w", err)
+	}
+	var doc struct {
+		Inbounds []struct {
+			Tag      string `json:"tag"`
+			Protocol string `json:"protocol"`
+			Listen   string `json:"listen"`
+			Port     int    `json:"port"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+	var out []Inbound
+	for _, in := range doc.Inbounds {
+		switch strings.ToLower(in.Protocol) {
+		case "socks", "http", "mixed":
+			out = append(out, Inbound{Tag: in.Tag, Protocol: strings.ToLower(in.Protocol), Listen: in.Listen, Port: in.Port})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,123 @@
+package bindmode
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestLoadOrCreateCredentials(t *testing.T) {
+	dir := t.TempDir()
+	first, created, err := LoadOrCreateCredentials(dir)
+	if err != nil || !created {
+		t.Fatalf("create credentials: created=%t err=%v", created, err)
+	}
+	if first.Username == "" || len(first.Password) < 32 {
+		t.Fatalf("unexpected credentials: %#v", first)
+	}
+	second, created, err := LoadOrCreateCredentials(dir)
+	if err != nil || created {
+		t.Fatalf("reload credentials: created=%t err=%v", created, err)
+	}
+	if *second != *first {
+		t.Fatalf("credentials changed: %#v != %#v", second, first)
+	}
+}
+
+func TestDenyList(t *testing.T) {
+	allow, err := ParseAllowList([]string{"192.168.1.0/24,10.0.0.7", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := denyList(allow)
+	denied := func(s string) bool {
+		addr := netip.MustParseAddr(s)
+		for _, p := range deny {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range []string{"192.168.1.1", "192.168.1.255", "10.0.0.7", "fd12::1"} {
+		if denied(s) {
+			t.Fatalf("%s should be allowed", s)
+		}
+	}
+	for _, s := range []string{"192.168.2.1", "10.0.0.8", "8.8.8.8", "0.0.0.0", "255.255.255.255", "2001:db8::1"} {
+		if !denied(s) {
+			t.Fatalf("%s should be denied", s)
+		}
+	}
+	if _, err := ParseAllowList([]string{"lan"}); err == nil {
+		t.Fatal("expected error for invalid entry")
+	}
+}
+
+func TestLocalPrefixesAllowBindAndInterfaces(t *testing.T) {
+	orig := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = orig })
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("10.8.0.2"), Mask: net.CIDRMask(24, 32)}}, nil
+	}
+	deny := denyList(append(localPrefixes("192.168.1.20"), netip.MustParsePrefix("172.16.0.0/12")))
+	for _, s := range []string{"127.0.0.1", "192.168.1.20", "10.8.0.2", "172.16.5.5"} {
+		for _, p := range deny {
+			if p.Contains(netip.MustParseAddr(s)) {
+				t.Fatalf("%s should be allowed", s)
+			}
+		}
+	}
+	if len(localPrefixes("0.0.0.0")) != 3 {
+		t.Fatal("a wildcard bind address should not be added")
+	}
+}
+
+func TestPrepareAppliesAccessControl(t *testing.T) {
+	tmp := t.TempDir()
+	xrayPath := filepath.Join(tmp, "xray.generated.json")
+	writeJSONFile(t, xrayPath, `{
+  "inbounds": [
+    {"tag":"socks","protocol":"socks","listen":"127.0.0.1","port":10808,"settings":{"udp":true}},
+    {"tag":"http","protocol":"http","listen":"127.0.0.1","port":10809},
+    {"tag":"dns","protocol":"dokodemo-door","listen":"127.0.0.1","port":53}
+  ],
+  "outbounds": [{"tag":"proxy","protocol":"vless"}],
+  "routing": {"rules":[{"type":"field","ip":["geoip:private"],"outboundTag":"proxy"}]}
+}`)
+	cfg := &config.File{App: config.App{GeneratedXrayConfig: xrayPath}}
+	creds := &Credentials{Username: "u", Password: "p"}
+	out, err := Prepare(cfg, tmp, Options{
+		Credentials: creds,
+		Allow:       []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+	})
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	doc := readJSONDoc(t, out.App.GeneratedXrayConfig)
+	inbounds := doc["inbounds"].([]any)
+	socks := inbounds[0].(map[string]any)["settings"].(map[string]any)
+	if socks["auth"] != "password" || socks["udp"] != true || len(socks["accounts"].([]any)) != 1 {
+		t.Fatalf("unexpected socks settings: %#v", socks)
+	}
+	httpSettings := inbounds[1].(map[string]any)["settings"].(map[string]any)
+	if _, ok := httpSettings["auth"]; ok || len(httpSettings["accounts"].([]any)) != 1 {
+		t.Fatalf("unexpected http settings: %#v", httpSettings)
+	}
+	if _, ok := inbounds[2].(map[string]any)["settings"]; ok {
+		t.Fatal("dokodemo-door inbound should be left alone")
+	}
+
+	outbounds := doc["outbounds"].([]any)
+	if outbounds[0].(map[string]any)["tag"] != "proxy" || outbounds[1].(map[string]any)["protocol"] != "blackhole" {
+		t.Fatalf("unexpected outbounds: %#v", outbounds)
+	}
+	rules := doc["routing"].(map[string]any)["rules"].([]any)
+	first := rules[0].(map[string]any)
+	if len(rules) != 2 || first["outboundTag"] != lanDenyOutboundTag || len(first["source"].([]any)) == 0 {
+		t.Fatalf("unexpected rules: %#v", rules)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -12,54 +13,235 @@ import (
 	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
-const bindAllHost = "0.0.0.0"
+const (
+	bindAllHost    = "0.0.0.0"
+	runtimeDirName = "runtime_bind_all"
+)
+
+// RuntimeXrayConfig is the xray config Prepare writes for conf-dir.
+func RuntimeXrayConfig(confDir string) string {
+	return filepath.Join(confDir, runtimeDirName, "xray.generated.bindall.json")
+}
+
+// Scope selects which listeners bind mode rewrites.
+type Scope string
+
+const (
+	// ScopeXray exposes only xray's inbounds; cores stay on loopback.
+	ScopeXray  Scope = "xray"
+	ScopeCores Scope = "cores"
+	ScopeAll   Scope = "all"
+)
+
+// Scopes lists the accepted scope names.
+var Scopes = []Scope{ScopeXray, ScopeCores, ScopeAll}
+
+// Options configures Prepare.
+type Options struct {
+	// Host is the listen address: 0.0.0.0, :: or the IP of one interface.
+	// Empty means 0.0.0.0.
+	Host string
+	// Scope defaults to ScopeXray.
+	Scope Scope
+	// Credentials, when set, become the account of xray's socks, http and
+	// mixed inbounds.
+	Credentials *Credentials
+	// Allow restricts xray's clients to these networks plus loopback; empty
+	// allows everyone.
+	Allow []netip.Prefix
+}
+
+// ParseScope validates a scope name; empty selects ScopeXray.
+func ParseScope(s string) (Scope, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return ScopeXray, nil
+	}
+	for _, scope := range Scopes {
+		if Scope(s) == scope {
+			return scope, nil
+		}
+	}
+	return "", fmt.Errorf("unknown bind scope %q (want xray, cores or all)", s)
+}
+
+// ParseHost validates a bind address, accepting bracketed IPv6.
+func ParseHost(s string) (string, error) {
+	host := strings.Trim(strings.TrimSpace(s), "[]")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("bind address %q is not an IP address", s)
+	}
+	return ip.String(), nil
+}
 
+func (s Scope) xray() bool  { return s == ScopeXray || s == ScopeAll }
+func (s Scope) cores() bool { return s == ScopeCores || s == ScopeAll }
+
+// exposes reports whether an xray inbound is rebound in this scope. The
+// per-core inbounds xraygen generates belong to the cores; its via inbounds
+// only serve local cores and stay on loopback.
+func (s Scope) exposes(inbound map[string]any) bool {
+	tag, _ := inbound["tag"].(string)
+	if strings.HasPrefix(tag, config.ViaInboundTagPrefix) {
+		return false
+	}
+	if strings.HasPrefix(tag, config.CoreInboundTagPrefix) {
+		return s.cores()
+	}
+	return s.xray()
+}
+
+// PrepareForBindAll binds xray and all cores to 0.0.0.0.
 func PrepareForBindAll(cfg *config.File, confDir string) (*config.File, error) {
+	return Prepare(cfg, confDir, Options{Host: bindAllHost, Scope: ScopeAll})
+}
+
+// Prepare writes runtime copies of the xray and core configs with their
+// listen addresses rewritten to opts.Host for the processes in opts.Scope.
+// When cores are rebound, the socks outbounds xraygen generated for them are
+// pointed at an address that reaches the new listener.
+func Prepare(cfg *config.File, confDir string, opts Options) (*config.File, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("nil config")
 	}
+	host := opts.Host
+	if host == "" {
+		host = bindAllHost
+	}
+	scope := opts.Scope
+	if scope == "" {
+		scope = ScopeXray
+	}
 	out := cloneConfig(cfg)
-	runtimeDir := filepath.Join(confDir, "runtime_bind_all")
+	runtimeDir := filepath.Join(confDir, runtimeDirName)
 	if err := os.MkdirAll(runtimeDir, 0o755); err != nil {
 		return nil, fmt.Errorf("create bind-all runtime dir: %w", err)
 	}
 
-	xrayOutPath := filepath.Join(runtimeDir, "xray.generated.bindall.json")
-	if err := patchListenConfigFile(out.App.GeneratedXrayConfig, xrayOutPath, bindAllHost, true); err != nil {
+	if scope.cores() {
+		for i := range out.Cores {
+			coreName := sanitizeName(out.Cores[i].Alias)
+			if coreName == "" {
+				coreName = sanitizeName(out.Cores[i].Name)
+			}
+			if coreName == "" {
+				coreName = fmt.Sprintf("core-%d", i+1)
+			}
+			ext := ".json"
+			switch strings.ToLower(filepath.Ext(out.Cores[i].Config)) {
+			case ".yaml", ".yml":
+				ext = ".yaml"
+			}
+			coreOutPath := filepath.Join(runtimeDir, fmt.Sprintf("%02d-%s.bindall%s", i+1, coreName, ext))
+			if err := patchListenConfigFile(out.Cores[i].Config, coreOutPath, host); err != nil {
+				return nil, fmt.Errorf("patch core %q config: %w", out.Cores[i].Name, err)
+			}
+			out.Cores[i].Config = coreOutPath
+			out.Cores[i].Listen.Host = host
+		}
+	}
+
+	xrayOutPath := RuntimeXrayConfig(confDir)
+	opts.Host, opts.Scope = host, scope
+	if err := patchXrayConfig(out, xrayOutPath, opts); err != nil {
 		return nil, fmt.Errorf("patch xray generated config: %w", err)
 	}
 	out.App.GeneratedXrayConfig = xrayOutPath
 
-	for i := range out.Cores {
-		coreName := sanitizeName(out.Cores[i].Alias)
-		if coreName == "" {
-			coreName = sanitizeName(out.Cores[i].Name)
+	return out, nil
+}
+
+// patchXrayConfig writes the runtime xray config: the inbounds in scope are
+// rebound and get the access control, core outbounds are redirected when
+// cores are in scope.
+func patchXrayConfig(cfg *config.File, dst string, opts Options) error {
+	b, err := os.ReadFile(cfg.App.GeneratedXrayConfig)
+	if err != nil {
+		return fmt.Errorf("read source config: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("parse config %s: %w", cfg.App.GeneratedXrayConfig, err)
+	}
+	if ensureInboundListenHost(doc, opts.Host, opts.Scope) {
+		if opts.Credentials != nil {
+			secureInbounds(doc, opts.Credentials, opts.Scope)
 		}
-		if coreName == "" {
-			coreName = fmt.Sprintf("core-%d", i+1)
+		if len(opts.Allow) > 0 {
+			restrictClients(doc, opts.Allow, opts.Host)
 		}
-		coreOutPath := filepath.Join(runtimeDir, fmt.Sprintf("%02d-%s.bindall.json", i+1, coreName))
-		if err := patchListenConfigFile(out.Cores[i].Config, coreOutPath, bindAllHost, false); err != nil {
-			return nil, fmt.Errorf("patch core %q config: %w", out.Cores[i].Name, err)
+	}
+	if opts.Scope.cores() {
+		redirectCoreOutbounds(doc, cfg.Cores, dialHost(opts.Host))
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal patched config: %w", err)
+	}
+	if err := os.WriteFile(dst, out, 0o644); err != nil {
+		return fmt.Errorf("write patched config: %w", err)
+	}
+	return nil
+}
+
+// redirectCoreOutbounds points the socks outbound of each core at address.
+func redirectCoreOutbounds(doc any, cores []config.Core, address string) {
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+	outbounds, _ := m["outbounds"].([]any)
+	for _, c := range cores {
+		tag := strings.TrimSpace(c.OutboundTag)
+		if tag == "" {
+			tag = strings.TrimSpace(c.Alias)
+		}
+		for _, raw := range outbounds {
+			ob, ok := raw.(map[string]any)
+			if !ok || ob["tag"] != tag || ob["protocol"] != "socks" {
+				continue
+			}
+			settings, _ := ob["settings"].(map[string]any)
+			servers, _ := settings["servers"].([]any)
+			for _, rawServer := range servers {
+				server, ok := rawServer.(map[string]any)
+				if !ok {
+					continue
+				}
+				if port, ok := server["port"].(float64); ok && int(port) == c.Listen.Port {
+					server["address"] = address
+				}
+			}
 		}
-		out.Cores[i].Config = coreOutPath
-		out.Cores[i].Listen.Host = bindAllHost
 	}
+}
 
-	return out, nil
+// dialHost maps a wildcard listen address to the loopback address of the
+// same family.
+func dialHost(host string) string {
+	switch host {
+	case "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	default:
+		return host
+	}
 }
 
 func cloneConfig(cfg *config.File) *config.File {
 	cp := *cfg
 	cp.Xray.Args = append([]string(nil), cfg.Xray.Args...)
 	cp.Cores = append([]config.Core(nil), cfg.Cores...)
+	cp.Outbounds = append([]config.Outbound(nil), cfg.Outbounds...)
 	for i := range cp.Cores {
 		cp.Cores[i].Args = append([]string(nil), cfg.Cores[i].Args...)
 	}
 	return &cp
 }
 
-func patchListenConfigFile(src, dst, host string, ensureInboundListen bool) error {
+func patchListenConfigFile(src, dst, host string) error {
 	b, err := os.ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("read source config: %w", err)
@@ -67,13 +249,17 @@ func patchListenConfigFile(src, dst, host string, ensureInboundListen bool) erro
 
 	var doc any
 	if err := json.Unmarshal(b, &doc); err != nil {
-		return fmt.Errorf("parse json config %s: %w", src, err)
+		patched, yamlErr := patchYAMLListen(b, host)
+		if yamlErr != nil {
+			return fmt.Errorf("parse config %s as json or yaml: %w", src, err)
+		}
+		if err := os.WriteFile(dst, patched, 0o644); err != nil {
+			return fmt.Errorf("write patched config: %w", err)
+		}
+		return nil
 	}
 
 	updated, _ := rewriteListenAny(doc, host)
-	if ensureInboundListen {
-		updated, _ = ensureInboundListenHost(updated, host)
-	}
 
 	out, err := json.MarshalIndent(updated, "", "  ")
 	if err != nil {
@@ -85,40 +271,31 @@ func patchListenConfigFile(src, dst, host string, ensureInboundListen bool) erro
 	return nil
 }
 
-func ensureInboundListenHost(v any, host string) (any, bool) {
+// ensureInboundListenHost rebinds the inbounds scope exposes to host and
+// reports whether any is exposed.
+func ensureInboundListenHost(v any, host string, scope Scope) bool {
 	m, ok := v.(map[string]any)
 	if !ok {
-		return v, false
+		return false
 	}
-	inbounds, ok := m["inbounds"].([]any)
-	if !ok {
-		return v, false
-	}
-	changed := false
-	for i, raw := range inbounds {
+	inbounds, _ := m["inbounds"].([]any)
+	exposed := false
+	for _, raw := range inbounds {
 		inbound, ok := raw.(map[string]any)
-		if !ok {
+		if !ok || !scope.exposes(inbound) {
 			continue
 		}
+		exposed = true
 		listenVal, hasListen := inbound["listen"]
 		if !hasListen {
 			inbound["listen"] = host
-			inbounds[i] = inbound
-			changed = true
 			continue
 		}
-		rewritten, itemChanged := rewriteListenValue(listenVal, host)
-		if itemChanged {
+		if rewritten, changed := rewriteListenValue(listenVal, host); changed {
 			inbound["listen"] = rewritten
-			inbounds[i] = inbound
-			changed = true
 		}
 	}
-	if changed {
-		m["inbounds"] = inbounds
-		return m, true
-	}
-	return v, false
+	return exposed
 }
 
 func rewriteListenAny(v any, host string) (any, bool) {
@@ -204,6 +381,8 @@ func rewriteListenString(s, host string) (string, bool) {
 		port := u.Port()
 		if port != "" {
 			u.Host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
 		} else {
 			u.Host = host
 		}
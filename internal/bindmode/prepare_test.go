@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPrepareForBindAll(t *testing.T) {
@@ -112,6 +113,155 @@ func TestPrepareForBindAllRejectsNonJSONCoreConfig(t *testing.T) {
 	}
 }
 
+func TestPrepareForBindAllRewritesMihomoYAML(t *testing.T) {
+	tmp := t.TempDir()
+	xrayPath := filepath.Join(tmp, "xray.generated.json")
+	corePath := filepath.Join(tmp, "mihomo.yaml")
+
+	writeJSONFile(t, xrayPath, `{"inbounds":[{"tag":"in-socks","listen":"127.0.0.1","port":10808}]}`)
+	writeJSONFile(t, corePath, `# mihomo profile
+mixed-port: 7890
+bind-address: 127.0.0.1
+listeners:
+  - name: socks-in
+    type: socks
+    port: 7891
+    listen: 127.0.0.1
+proxies: []
+`)
+
+	cfg := &config.File{
+		App: config.App{GeneratedXrayConfig: xrayPath},
+		Cores: []config.Core{{
+			Name:   "mihomo",
+			Alias:  "mihomo",
+			Config: corePath,
+			Listen: config.Listen{Host: "127.0.0.1", Port: 7890},
+		}},
+	}
+	out, err := PrepareForBindAll(cfg, tmp)
+	if err != nil {
+		t.Fatalf("prepare bind-all failed: %v", err)
+	}
+	if filepath.Ext(out.Cores[0].Config) != ".yaml" {
+		t.Fatalf("runtime copy should keep yaml extension: %s", out.Cores[0].Config)
+	}
+	b, err := os.ReadFile(out.Cores[0].Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("runtime copy is not yaml: %v", err)
+	}
+	if doc["bind-address"] != bindAllHost || doc["allow-lan"] != true {
+		t.Fatalf("unexpected bind settings: %#v", doc)
+	}
+	listener := doc["listeners"].([]any)[0].(map[string]any)
+	if listener["listen"] != bindAllHost {
+		t.Fatalf("unexpected listener listen: %#v", listener)
+	}
+	if doc["mixed-port"] != 7890 {
+		t.Fatalf("other keys should be kept: %#v", doc)
+	}
+}
+
+func TestPrepareScopes(t *testing.T) {
+	tmp := t.TempDir()
+	xrayPath := filepath.Join(tmp, "xray.generated.json")
+	corePath := filepath.Join(tmp, "core.json")
+	writeJSONFile(t, xrayPath, `{
+  "inbounds": [
+    {"tag":"in-socks","listen":"127.0.0.1","port":10808},
+    {"tag":"core-in-naive-a","protocol":"socks","listen":"127.0.0.1","port":20001,"settings":{"auth":"noauth"}}
+  ],
+  "outbounds": [
+    {"tag":"naive-a","protocol":"socks","settings":{"servers":[{"address":"127.0.0.1","port":1081}]}},
+    {"tag":"direct","protocol":"freedom"}
+  ]
+}`)
+	writeJSONFile(t, corePath, `{"listen":"socks://127.0.0.1:1081"}`)
+	cfg := &config.File{
+		App: config.App{GeneratedXrayConfig: xrayPath},
+		Cores: []config.Core{{
+			Name:   "naive-a",
+			Alias:  "naive-a",
+			Config: corePath,
+			Listen: config.Listen{Host: "127.0.0.1", Port: 1081},
+		}},
+	}
+	inboundListen := func(out *config.File) any {
+		return readJSONDoc(t, out.App.GeneratedXrayConfig)["inbounds"].([]any)[0].(map[string]any)["listen"]
+	}
+	coreInbound := func(out *config.File) map[string]any {
+		return readJSONDoc(t, out.App.GeneratedXrayConfig)["inbounds"].([]any)[1].(map[string]any)
+	}
+	outboundAddress := func(out *config.File) any {
+		ob := readJSONDoc(t, out.App.GeneratedXrayConfig)["outbounds"].([]any)[0].(map[string]any)
+		return ob["settings"].(map[string]any)["servers"].([]any)[0].(map[string]any)["address"]
+	}
+
+	out, err := Prepare(cfg, tmp, Options{Host: "192.168.1.5"})
+	if err != nil {
+		t.Fatalf("prepare xray scope: %v", err)
+	}
+	if inboundListen(out) != "192.168.1.5" || outboundAddress(out) != "127.0.0.1" {
+		t.Fatalf("xray scope: inbound %v, outbound %v", inboundListen(out), outboundAddress(out))
+	}
+	if out.Cores[0].Config != corePath || out.Cores[0].Listen.Host != "127.0.0.1" {
+		t.Fatalf("xray scope should keep cores on loopback: %#v", out.Cores[0])
+	}
+	if in := coreInbound(out); in["listen"] != "127.0.0.1" {
+		t.Fatalf("xray scope should keep core inbounds on loopback: %#v", in)
+	}
+
+	out, err = Prepare(cfg, tmp, Options{Host: "192.168.1.5", Scope: ScopeCores})
+	if err != nil {
+		t.Fatalf("prepare cores scope: %v", err)
+	}
+	if inboundListen(out) != "127.0.0.1" || outboundAddress(out) != "192.168.1.5" {
+		t.Fatalf("cores scope: inbound %v, outbound %v", inboundListen(out), outboundAddress(out))
+	}
+	if doc := readJSONDoc(t, out.Cores[0].Config); doc["listen"] != "socks://192.168.1.5:1081" {
+		t.Fatalf("cores scope: unexpected core listen %v", doc["listen"])
+	}
+	if in := coreInbound(out); in["listen"] != "192.168.1.5" {
+		t.Fatalf("cores scope: core inbound should be rebound: %#v", in)
+	}
+
+	out, err = Prepare(cfg, tmp, Options{Host: "::", Scope: ScopeAll, Credentials: &Credentials{Username: "u", Password: "p"}})
+	if err != nil {
+		t.Fatalf("prepare all scope: %v", err)
+	}
+	if inboundListen(out) != "::" || outboundAddress(out) != "::1" || out.Cores[0].Listen.Host != "::" {
+		t.Fatalf("all scope: inbound %v, outbound %v, core %#v", inboundListen(out), outboundAddress(out), out.Cores[0].Listen)
+	}
+	if doc := readJSONDoc(t, out.Cores[0].Config); doc["listen"] != "socks://[::]:1081" {
+		t.Fatalf("all scope: unexpected core listen %v", doc["listen"])
+	}
+	if in := coreInbound(out); in["listen"] != "::" || in["settings"].(map[string]any)["auth"] != "password" {
+		t.Fatalf("all scope: core inbound should be rebound with the account: %#v", in)
+	}
+}
+
+func TestParseHostAndScope(t *testing.T) {
+	for in, want := range map[string]string{"0.0.0.0": "0.0.0.0", "[::]": "::", " 192.168.1.5 ": "192.168.1.5"} {
+		got, err := ParseHost(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseHost(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseHost("eth0"); err == nil {
+		t.Fatal("expected error for interface name")
+	}
+	if scope, err := ParseScope(""); err != nil || scope != ScopeXray {
+		t.Fatalf("default scope = %q, %v", scope, err)
+	}
+	if _, err := ParseScope("lan"); err == nil {
+		t.Fatal("expected error for unknown scope")
+	}
+}
+
 func writeJSONFile(t *testing.T, path, content string) {
 	t.Helper()
 	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
@@ -0,0 +1,89 @@
+package bindmode
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mihomoPortKeys marks a YAML document as a mihomo/clash profile whose
+// top-level inbound ports are bound to bind-address.
+var mihomoPortKeys = []string{"port", "socks-port", "mixed-port", "redir-port", "tproxy-port"}
+
+// patchYAMLListen rewrites bind-address and every listen value of a YAML
+// config, keeping key order and comments.
+func patchYAMLListen(b []byte, host string) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("yaml config is not a mapping")
+	}
+	doc := root.Content[0]
+	if hasAnyKey(doc, mihomoPortKeys) {
+		setMappingValue(doc, "bind-address", "!!str", host)
+		if !isLoopbackHost(host) {
+			// mihomo ignores bind-address for LAN addresses unless allow-lan is set.
+			setMappingValue(doc, "allow-lan", "!!bool", "true")
+		}
+	}
+	rewriteYAMLListen(doc, host)
+	return yaml.Marshal(&root)
+}
+
+func rewriteYAMLListen(n *yaml.Node, host string) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if strings.EqualFold(key.Value, "listen") && val.Kind == yaml.ScalarNode {
+				if rewritten, changed := rewriteListenString(val.Value, host); changed {
+					val.Value = rewritten
+					val.Tag = "!!str"
+					val.Style = 0
+				}
+				continue
+			}
+			rewriteYAMLListen(val, host)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			rewriteYAMLListen(item, host)
+		}
+	}
+}
+
+func hasAnyKey(mapping *yaml.Node, keys []string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		for _, k := range keys {
+			if mapping.Content[i].Value == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func setMappingValue(mapping *yaml.Node, key, tag, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value},
+	)
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
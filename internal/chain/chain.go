@@ -0,0 +1,266 @@
+// Package chain sends the upstream connections of external cores through
+// other cores or xray outbounds by adding a socks proxy to runtime copies of
+// their configs.
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	runtimeDirName = "runtime_chain"
+	// upstreamTag names the socks proxy added to a chained core's config.
+	upstreamTag = "coremesh-via"
+)
+
+// Prepare writes a runtime copy of the config of every core with a via and
+// returns a copy of cfg that points at them. cfg itself is returned when no
+// core is chained. A core chained through an xray outbound dials the via
+// inbound xraygen generated for it. Call it after bind mode so the upstream
+// address matches the listener the via core really uses.
+func Prepare(cfg *config.File, confDir string) (*config.File, error) {
+	vias, err := cfg.Vias()
+	if err != nil {
+		return nil, err
+	}
+	listeners := make(map[string]config.Listen, len(cfg.Cores))
+	for _, c := range cfg.Cores {
+		listeners[c.Tag()] = c.Listen
+	}
+	var viaInbounds map[string]config.Listen
+	var out *config.File
+	for i, c := range cfg.Cores {
+		via, ok := vias[c.Tag()]
+		if !ok {
+			continue
+		}
+		upstream, ok := listeners[via]
+		if !ok {
+			if viaInbounds == nil {
+				if viaInbounds, err = readViaInbounds(cfg.App.GeneratedXrayConfig); err != nil {
+					return nil, err
+				}
+			}
+			if upstream, ok = viaInbounds[via]; !ok {
+				return nil, fmt.Errorf("core %s: %s has no via inbound for %q; run parse again", c.Name, cfg.App.GeneratedXrayConfig, via)
+			}
+		}
+		if out == nil {
+			cp := *cfg
+			cp.Cores = append([]config.Core(nil), cfg.Cores...)
+			out = &cp
+			if err := os.MkdirAll(filepath.Join(confDir, runtimeDirName), 0o755); err != nil {
+				return nil, fmt.Errorf("create chain runtime dir: %w", err)
+			}
+		}
+		ext := ".json"
+		switch strings.ToLower(filepath.Ext(c.Config)) {
+		case ".yaml", ".yml":
+			ext = ".yaml"
+		}
+		dst := filepath.Join(confDir, runtimeDirName, fmt.Sprintf("core-%02d.chain%s", i+1, ext))
+		if err := patchConfigFile(c.Config, dst, dialHost(upstream.Host), upstream.Port); err != nil {
+			return nil, fmt.Errorf("chain core %s through %s: %w", c.Name, via, err)
+		}
+		out.Cores[i].Config = dst
+	}
+	if out == nil {
+		return cfg, nil
+	}
+	return out, nil
+}
+
+// readViaInbounds maps the outbound tags of the via inbounds in an xray
+// config to their listeners.
+func readViaInbounds(xrayConfig string) (map[string]config.Listen, error) {
+	b, err := os.ReadFile(xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read xray config: %w", err)
+	}
+	var doc struct {
+		Inbounds []struct {
+			Tag    string `json:"tag"`
+			Listen string `json:"listen"`
+			Port   int    `json:"port"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+	out := make(map[string]config.Listen)
+	for _, in := range doc.Inbounds {
+		if via, ok := strings.CutPrefix(in.Tag, config.ViaInboundTagPrefix); ok {
+			out[via] = config.Listen{Host: in.Listen, Port: in.Port}
+		}
+	}
+	return out, nil
+}
+
+// dialHost maps a wildcard listen address to the loopback address of the
+// same family.
+func dialHost(host string) string {
+	switch strings.Trim(strings.TrimSpace(host), "[]") {
+	case "", "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	default:
+		return strings.Trim(strings.TrimSpace(host), "[]")
+	}
+}
+
+// CheckConfig reports an error when the core config at path is not in a
+// format Prepare can chain, so parse rejects it instead of run.
+func CheckConfig(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read core config: %w", err)
+	}
+	_, err = patchConfig(b, "127.0.0.1", 1080)
+	return err
+}
+
+func patchConfigFile(src, dst, host string, port int) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("read core config: %w", err)
+	}
+	patched, err := patchConfig(b, host, port)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, patched, 0o644); err != nil {
+		return fmt.Errorf("write core config: %w", err)
+	}
+	return nil
+}
+
+func patchConfig(b []byte, host string, port int) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return patchMihomo(b, host, port)
+	}
+	if err := patchJSON(doc, host, port); err != nil {
+		return nil, err
+	}
+	patched, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal core config: %w", err)
+	}
+	return patched, nil
+}
+
+// patchJSON handles xray/v2ray configs, whose outbounds carry a protocol,
+// and sing-box configs, whose outbounds carry a type.
+func patchJSON(doc map[string]any, host string, port int) error {
+	outbounds, _ := doc["outbounds"].([]any)
+	if len(outbounds) == 0 {
+		return fmt.Errorf("config has no outbounds to chain (supported: xray/v2ray or sing-box json, mihomo yaml)")
+	}
+	first, _ := outbounds[0].(map[string]any)
+	if _, singBox := first["type"]; singBox {
+		for _, raw := range outbounds {
+			ob, ok := raw.(map[string]any)
+			if !ok {
+				continue
+			}
+			switch ob["type"] {
+			case "direct", "block", "dns", "selector", "urltest":
+				continue
+			}
+			if _, set := ob["detour"]; !set {
+				ob["detour"] = upstreamTag
+			}
+		}
+		doc["outbounds"] = append(outbounds, map[string]any{
+			"type":        "socks",
+			"tag":         upstreamTag,
+			"server":      host,
+			"server_port": port,
+		})
+		return nil
+	}
+	for _, raw := range outbounds {
+		ob, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch ob["protocol"] {
+		case "freedom", "blackhole", "dns", "loopback":
+			continue
+		}
+		if _, set := ob["proxySettings"]; set {
+			continue
+		}
+		stream, _ := ob["streamSettings"].(map[string]any)
+		if stream == nil {
+			stream = map[string]any{}
+		}
+		sockopt, _ := stream["sockopt"].(map[string]any)
+		if sockopt == nil {
+			sockopt = map[string]any{}
+		}
+		if _, set := sockopt["dialerProxy"]; !set {
+			sockopt["dialerProxy"] = upstreamTag
+		}
+		stream["sockopt"] = sockopt
+		ob["streamSettings"] = stream
+	}
+	doc["outbounds"] = append(outbounds, map[string]any{
+		"tag":      upstreamTag,
+		"protocol": "socks",
+		"settings": map[string]any{
+			"servers": []any{map[string]any{"address": host, "port": port}},
+		},
+	})
+	return nil
+}
+
+// patchMihomo adds the upstream to a mihomo/clash profile and sets it as the
+// dialer-proxy of every proxy that has none, keeping key order and comments.
+func patchMihomo(b []byte, host string, port int) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("parse core config as json or yaml: %w", err)
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("yaml config is not a mapping")
+	}
+	proxies := mappingValue(root.Content[0], "proxies")
+	if proxies == nil || proxies.Kind != yaml.SequenceNode || len(proxies.Content) == 0 {
+		return nil, fmt.Errorf("yaml config has no proxies to chain (supported: mihomo/clash profiles)")
+	}
+	for _, p := range proxies.Content {
+		if p.Kind == yaml.MappingNode && mappingValue(p, "dialer-proxy") == nil {
+			p.Content = append(p.Content, scalar("!!str", "dialer-proxy"), scalar("!!str", upstreamTag))
+		}
+	}
+	proxies.Content = append(proxies.Content, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		scalar("!!str", "name"), scalar("!!str", upstreamTag),
+		scalar("!!str", "type"), scalar("!!str", "socks5"),
+		scalar("!!str", "server"), scalar("!!str", host),
+		scalar("!!str", "port"), scalar("!!int", fmt.Sprint(port)),
+		scalar("!!str", "udp"), scalar("!!bool", "true"),
+	}})
+	return yaml.Marshal(&root)
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -32,6 +34,30 @@ func LoadRouting(path string) (*Routing, error) {
 	return &cfg, nil
 }
 
+// LoadStandalone loads a hand-written main config together with the routing
+// file it references. Relative paths are resolved against the directory of
+// the main config file.
+func LoadStandalone(path string) (*File, *Routing, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve main config path: %w", err)
+	}
+	cfg, err := LoadMain(absPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolveRelativePaths(cfg, filepath.Dir(absPath))
+
+	routing := &Routing{}
+	if cfg.RoutingRulesFile != "" {
+		routing, err = LoadRouting(cfg.RoutingRulesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return cfg, routing, nil
+}
+
 func LoadCustomRules(path string) ([]map[string]any, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -46,3 +72,70 @@ func LoadCustomRules(path string) ([]map[string]any, error) {
 	}
 	return rules, nil
 }
+
+// LoadAssets reads the asset list from assets.yaml in the conf dir. A missing
+// file yields no entries.
+func LoadAssets(path string) ([]Asset, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read assets config: %w", err)
+	}
+	var doc struct {
+		Assets []Asset `yaml:"assets"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse assets config: %w", err)
+	}
+	return doc.Assets, nil
+}
+
+// LoadGroups reads core groups and probe settings from groups.yaml in the
+// conf dir. A missing file yields no groups.
+func LoadGroups(path string) ([]Group, Probe, error) {
+	var doc struct {
+		Groups []Group `yaml:"groups"`
+		Probe  Probe   `yaml:"probe"`
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, doc.Probe, nil
+		}
+		return nil, doc.Probe, fmt.Errorf("read groups config: %w", err)
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, doc.Probe, fmt.Errorf("parse groups config: %w", err)
+	}
+	return doc.Groups, doc.Probe, nil
+}
+
+func resolveRelativePaths(cfg *File, baseDir string) {
+	resolve := func(p *string) {
+		v := strings.TrimSpace(*p)
+		if v == "" || filepath.IsAbs(v) {
+			*p = v
+			return
+		}
+		*p = filepath.Join(baseDir, v)
+	}
+	// A bare command name such as "xray" is looked up in PATH at run time.
+	resolveBin := func(p *string) {
+		if v := strings.TrimSpace(*p); !strings.ContainsAny(v, `/\`) {
+			*p = v
+			return
+		}
+		resolve(p)
+	}
+	resolve(&cfg.App.WorkDir)
+	resolve(&cfg.App.GeneratedXrayConfig)
+	resolveBin(&cfg.Xray.Bin)
+	resolve(&cfg.Xray.BaseConfig)
+	resolve(&cfg.RoutingRulesFile)
+	for i := range cfg.Cores {
+		resolveBin(&cfg.Cores[i].Bin)
+		resolve(&cfg.Cores[i].Config)
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadStandaloneResolvesRelativePaths(t *testing.T) {
+	tmp := t.TempDir()
+	mainPath := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(mainPath, []byte(`xray:
+  bin: ./bin/xray
+  base_config: xray.base.json
+cores:
+  - name: naive-a
+    bin: /opt/naive/naive
+    config: ./cores/naive-a.json
+    listen:
+      host: 127.0.0.1
+      port: 11080
+    outbound_tag: core-naive-a
+routing_rules_file: rules.yaml
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "rules.yaml"), []byte(`rules:
+  - name: r1
+    domain: ["domain:example.com"]
+    outbound_tag: core-naive-a
+default_outbound_tag: direct
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, routing, err := LoadStandalone(mainPath)
+	if err != nil {
+		t.Fatalf("load standalone: %v", err)
+	}
+	if cfg.Xray.Bin != filepath.Join(tmp, "bin", "xray") {
+		t.Fatalf("unexpected xray bin: %s", cfg.Xray.Bin)
+	}
+	if cfg.Xray.BaseConfig != filepath.Join(tmp, "xray.base.json") {
+		t.Fatalf("unexpected xray base config: %s", cfg.Xray.BaseConfig)
+	}
+	if cfg.Cores[0].Bin != "/opt/naive/naive" {
+		t.Fatalf("absolute core bin should be kept: %s", cfg.Cores[0].Bin)
+	}
+	if cfg.Cores[0].Config != filepath.Join(tmp, "cores", "naive-a.json") {
+		t.Fatalf("unexpected core config: %s", cfg.Cores[0].Config)
+	}
+	if len(routing.Rules) != 1 || routing.Rules[0].OutboundTag != "core-naive-a" {
+		t.Fatalf("unexpected routing rules: %#v", routing.Rules)
+	}
+	if routing.DefaultOutboundTag != "direct" {
+		t.Fatalf("unexpected default outbound tag: %s", routing.DefaultOutboundTag)
+	}
+}
+
+func TestLoadStandaloneWithoutRoutingFile(t *testing.T) {
+	tmp := t.TempDir()
+	mainPath := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(mainPath, []byte("xray:\n  bin: xray\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, routing, err := LoadStandalone(mainPath)
+	if err != nil {
+		t.Fatalf("load standalone: %v", err)
+	}
+	if cfg.Xray.Bin != "xray" {
+		t.Fatalf("bare command name should be kept for PATH lookup: %s", cfg.Xray.Bin)
+	}
+	if routing == nil || len(routing.Rules) != 0 {
+		t.Fatalf("expected empty routing: %#v", routing)
+	}
+}
+
+func TestLoadAssets(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "assets.yaml")
+	list, err := LoadAssets(path)
+	if err != nil || list != nil {
+		t.Fatalf("missing assets.yaml should yield nothing: %#v %v", list, err)
+	}
+
+	content := `assets:
+  - name: geosite
+    urls:
+      - https://mirror.example/geosite.dat
+      - https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat
+    checksum_url: https://mirror.example/geosite.dat.sha256sum
+    max_age: 168h
+  - name: company
+    file: company.dat
+    urls: [https://intranet.example/company.dat]
+    optional: true
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	list, err = LoadAssets(path)
+	if err != nil {
+		t.Fatalf("load assets: %v", err)
+	}
+	if len(list) != 2 || len(list[0].URLs) != 2 || list[0].MaxAge != "168h" || list[0].ChecksumURL == "" {
+		t.Fatalf("unexpected assets: %#v", list)
+	}
+	if list[1].File != "company.dat" || !list[1].Optional {
+		t.Fatalf("unexpected second asset: %#v", list[1])
+	}
+}
+
+func TestLoadGroups(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "groups.yaml")
+	groups, _, err := LoadGroups(path)
+	if err != nil || groups != nil {
+		t.Fatalf("missing groups.yaml should yield nothing: %#v %v", groups, err)
+	}
+
+	content := `groups:
+  - name: us-exits
+    members: [naive-us1, naive-us2]
+    strategy: leastPing
+    fallback: direct
+probe:
+  url: https://probe.example/generate_204
+  interval: 30s
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	groups, probe, err := LoadGroups(path)
+	if err != nil {
+		t.Fatalf("load groups: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Members) != 2 || groups[0].Strategy != StrategyLeastPing || groups[0].Fallback != "direct" {
+		t.Fatalf("unexpected groups: %#v", groups)
+	}
+	if probe.URL != "https://probe.example/generate_204" || probe.Interval != "30s" {
+		t.Fatalf("unexpected probe: %#v", probe)
+	}
+}
@@ -1,5 +1,7 @@
 package config
 
+import "strings"
+
 type App struct {
 	WorkDir             string `yaml:"work_dir" json:"work_dir"`
 	GeneratedXrayConfig string `yaml:"generated_xray_config" json:"generated_xray_config"`
@@ -9,6 +11,17 @@ type Xray struct {
 	Bin        string   `yaml:"bin" json:"bin"`
 	BaseConfig string   `yaml:"base_config" json:"base_config"`
 	Args       []string `yaml:"args" json:"args"`
+	Restart    Restart  `yaml:"restart,omitempty" json:"restart,omitempty"`
+}
+
+// Restart configures how the runner reacts when a process exits on its own.
+// Policy is one of never, on-failure or always. Backoff and MaxBackoff are
+// Go duration strings; MaxRetries of 0 means unlimited.
+type Restart struct {
+	Policy     string `yaml:"policy,omitempty" json:"policy,omitempty"`
+	MaxRetries int    `yaml:"max_retries,omitempty" json:"max_retries,omitempty"`
+	Backoff    string `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	MaxBackoff string `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
 }
 
 type Listen struct {
@@ -27,22 +40,159 @@ type Core struct {
 	Args        []string `yaml:"args" json:"args"`
 	OutboundTag string   `yaml:"outbound_tag" json:"outbound_tag"`
 	Active      bool     `yaml:"active" json:"active"`
+	Restart     Restart  `yaml:"restart,omitempty" json:"restart,omitempty"`
+	Ready       Ready    `yaml:"ready,omitempty" json:"ready,omitempty"`
+	Via         string   `yaml:"via,omitempty" json:"via,omitempty"`
+}
+
+// Tag is the xray outbound tag of the core: OutboundTag, else Alias.
+func (c Core) Tag() string {
+	if tag := strings.TrimSpace(c.OutboundTag); tag != "" {
+		return tag
+	}
+	return strings.TrimSpace(c.Alias)
+}
+
+// Ready configures the startup probe against Core.Listen. Timeout is a Go
+// duration string; Socks additionally requires a SOCKS5 greeting reply.
+type Ready struct {
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Socks   bool   `yaml:"socks,omitempty" json:"socks,omitempty"`
 }
 
+// Outbound is a native xray outbound, e.g. imported from a standard v2rayN
+// profile. Xray holds the outbound object without its tag.
+type Outbound struct {
+	ProfileID string         `yaml:"profile_id,omitempty" json:"profile_id,omitempty"`
+	Name      string         `yaml:"name" json:"name"`
+	Tag       string         `yaml:"tag" json:"tag"`
+	Active    bool           `yaml:"active" json:"active"`
+	Xray      map[string]any `yaml:"xray" json:"xray"`
+	Via       string         `yaml:"via,omitempty" json:"via,omitempty"`
+}
+
+// Asset describes one file kept up to date in the conf dir, such as
+// geosite.dat. URLs are mirrors tried in order; MaxAge is a Go duration
+// string. An optional asset that cannot be fetched only produces a warning.
+type Asset struct {
+	Name        string   `yaml:"name" json:"name"`
+	File        string   `yaml:"file,omitempty" json:"file,omitempty"`
+	URLs        []string `yaml:"urls" json:"urls"`
+	ChecksumURL string   `yaml:"checksum_url,omitempty" json:"checksum_url,omitempty"`
+	MaxAge      string   `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+	Optional    bool     `yaml:"optional,omitempty" json:"optional,omitempty"`
+}
+
+// CoreInboundTagPrefix starts the tag of every generated per-core inbound.
+const CoreInboundTagPrefix = "core-in-"
+
+// ViaInboundTagPrefix starts the tag of every generated inbound through
+// which a chained core reaches the xray outbound named in its via.
+const ViaInboundTagPrefix = "via-in-"
+
+// DefaultViaBasePort is the first port tried for via inbounds.
+const DefaultViaBasePort = 10870
+
+// CoreInbounds asks for one xray inbound per core, on consecutive ports from
+// BasePort in core order, routed straight to that core. Protocol is socks
+// (default) or mixed; Listen defaults to 127.0.0.1.
+type CoreInbounds struct {
+	BasePort int    `yaml:"base_port" json:"base_port"`
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Listen   string `yaml:"listen,omitempty" json:"listen,omitempty"`
+}
+
+// Balancer strategies accepted by Group.Strategy.
+const (
+	StrategyRandom     = "random"
+	StrategyLeastPing  = "leastPing"
+	StrategyLeastLoad  = "leastLoad"
+	StrategyRoundRobin = "roundRobin"
+)
+
+// Strategies lists the accepted balancer strategies.
+var Strategies = []string{StrategyRandom, StrategyLeastPing, StrategyLeastLoad, StrategyRoundRobin}
+
+// Group puts interchangeable outbounds, usually cores, behind an xray
+// balancer tagged Name. Members are outbound tags; Strategy defaults to
+// random. Fallback is used when no member is alive. Via chains every member
+// without a via of its own.
+type Group struct {
+	Name     string   `yaml:"name" json:"name"`
+	Members  []string `yaml:"members" json:"members"`
+	Strategy string   `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	Fallback string   `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	Via      string   `yaml:"via,omitempty" json:"via,omitempty"`
+}
+
+// Probe configures the health checks of group members. URL and Interval
+// default to an HTTP 204 endpoint and one minute.
+type Probe struct {
+	URL      string `yaml:"url,omitempty" json:"url,omitempty"`
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+}
+
+// Routing modes. Rule follows the routing rules and falls back to the
+// default outbound; global sends everything through one outbound and direct
+// sends everything out directly. Per-core inbounds are pinned in every mode.
+const (
+	ModeRule   = "rule"
+	ModeGlobal = "global"
+	ModeDirect = "direct"
+)
+
+// Modes lists the accepted routing modes.
+var Modes = []string{ModeRule, ModeGlobal, ModeDirect}
+
 type File struct {
-	App              App    `yaml:"app" json:"app"`
-	Xray             Xray   `yaml:"xray" json:"xray"`
-	Cores            []Core `yaml:"cores" json:"cores"`
-	RoutingRulesFile string `yaml:"routing_rules_file,omitempty" json:"routing_rules_file,omitempty"`
+	App              App              `yaml:"app" json:"app"`
+	Xray             Xray             `yaml:"xray" json:"xray"`
+	Cores            []Core           `yaml:"cores" json:"cores"`
+	Outbounds        []Outbound       `yaml:"outbounds,omitempty" json:"outbounds,omitempty"`
+	RoutingRulesFile string           `yaml:"routing_rules_file,omitempty" json:"routing_rules_file,omitempty"`
+	CoreInbounds     *CoreInbounds    `yaml:"core_inbounds,omitempty" json:"core_inbounds,omitempty"`
+	ViaBasePort      int              `yaml:"via_base_port,omitempty" json:"via_base_port,omitempty"`
+	Mode             string           `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Groups           []Group          `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Probe            Probe            `yaml:"probe,omitempty" json:"probe,omitempty"`
+	Skipped          []SkippedProfile `yaml:"-" json:"-"`
 }
 
+// SkippedProfile reports a source profile that could not be translated.
+type SkippedProfile struct {
+	ProfileID string
+	Name      string
+	Reason    string
+}
+
+// RoutingRule maps to one xray routing rule; all non-empty matchers must match.
+// A rule sends traffic to OutboundTag, or to the balancer of Group.
 type RoutingRule struct {
 	Name        string   `yaml:"name" json:"name"`
 	Domain      []string `yaml:"domain" json:"domain"`
+	IP          []string `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Port        string   `yaml:"port,omitempty" json:"port,omitempty"`
+	Network     string   `yaml:"network,omitempty" json:"network,omitempty"`
+	Protocol    []string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	InboundTag  []string `yaml:"inbound_tag,omitempty" json:"inbound_tag,omitempty"`
+	Process     []string `yaml:"process,omitempty" json:"process,omitempty"`
 	OutboundTag string   `yaml:"outbound_tag" json:"outbound_tag"`
+	Group       string   `yaml:"group,omitempty" json:"group,omitempty"`
+}
+
+// SkippedRule reports a source rule that could not be translated.
+type SkippedRule struct {
+	Index  int
+	Name   string
+	Reason string
 }
 
+// Routing is the imported rule set. Unmatched traffic goes to
+// DefaultOutboundTag, or to the first outbound of the base config when it is
+// empty. GlobalOutboundTag is used by global mode and defaults to proxy.
 type Routing struct {
 	Rules              []RoutingRule `yaml:"rules" json:"rules"`
 	DefaultOutboundTag string        `yaml:"default_outbound_tag" json:"default_outbound_tag"`
+	GlobalOutboundTag  string        `yaml:"global_outbound_tag,omitempty" json:"global_outbound_tag,omitempty"`
+	Skipped            []SkippedRule `yaml:"-" json:"-"`
 }
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Vias maps the tag of every chained core and outbound to the outbound its
+// upstream connections go through. A group's via applies to members without
+// their own; members of several groups must not get conflicting vias.
+func (f *File) Vias() (map[string]string, error) {
+	vias := make(map[string]string)
+	for _, c := range f.Cores {
+		if via := strings.TrimSpace(c.Via); via != "" {
+			vias[c.Tag()] = via
+		}
+	}
+	for _, ob := range f.Outbounds {
+		if via := strings.TrimSpace(ob.Via); via != "" {
+			vias[strings.TrimSpace(ob.Tag)] = via
+		}
+	}
+	own := make(map[string]struct{}, len(vias))
+	for tag := range vias {
+		own[tag] = struct{}{}
+	}
+	from := make(map[string]string)
+	for _, g := range f.Groups {
+		via := strings.TrimSpace(g.Via)
+		if via == "" {
+			continue
+		}
+		for _, m := range g.Members {
+			if _, ok := own[m]; ok {
+				continue
+			}
+			if prev, ok := vias[m]; ok && prev != via {
+				return nil, fmt.Errorf("%s gets via %q from group %s and via %q from group %s", m, prev, from[m], via, g.Name)
+			}
+			vias[m] = via
+			from[m] = g.Name
+		}
+	}
+	return vias, nil
+}
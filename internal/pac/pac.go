@@ -0,0 +1,385 @@
+// Package pac builds a proxy auto-config script from the domain rules of a
+// generated xray config.
+package pac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets"
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
+)
+
+// ContentType is the MIME type browsers expect for PAC files.
+const ContentType = "application/x-ns-proxy-autoconfig"
+
+// Rule is one routing rule reduced to the domain matchers a PAC script can
+// evaluate.
+type Rule struct {
+	// Index is the zero-based position in routing.rules.
+	Index    int
+	Direct   bool
+	Domains  []string // the domain and its subdomains
+	Full     []string
+	Keywords []string
+	Regexps  []string
+}
+
+// Table is the ordered list of rules plus the decision when none matches.
+type Table struct {
+	Rules         []Rule
+	DefaultDirect bool
+	Inbound       *sysproxy.InboundEndpoint
+}
+
+// Load reads xrayConfig and keeps the rules that can be decided from the host
+// name alone. Geosite references are expanded from the .dat files in
+// assetDir. Anything the script cannot decide exactly is left to xray: a
+// direct rule with extra conditions is dropped, a proxy rule is kept on its
+// domains only, so a host is never sent DIRECT when xray would proxy it.
+func Load(xrayConfig, assetDir string) (*Table, error) {
+	ep, err := sysproxy.DetectProxyEndpoint(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	switch ep.Protocol {
+	case "http", "mixed", "socks":
+	default:
+		return nil, fmt.Errorf("inbound protocol %q cannot be used in a PAC file", ep.Protocol)
+	}
+	b, err := os.ReadFile(xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read xray config: %w", err)
+	}
+	var doc struct {
+		Outbounds []struct {
+			Tag      string `json:"tag"`
+			Protocol string `json:"protocol"`
+		} `json:"outbounds"`
+		Routing struct {
+			DomainStrategy string           `json:"domainStrategy"`
+			Rules          []map[string]any `json:"rules"`
+		} `json:"routing"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+	direct := map[string]bool{}
+	for _, ob := range doc.Outbounds {
+		if strings.EqualFold(ob.Protocol, "freedom") {
+			direct[ob.Tag] = true
+		}
+	}
+
+	t := &Table{Inbound: ep}
+	if len(doc.Outbounds) > 0 {
+		t.DefaultDirect = direct[doc.Outbounds[0].Tag]
+	}
+	geo := &geoSites{dir: assetDir, cache: map[string][]assets.GeoDomain{}}
+	// With IPOnDemand a proxy rule on IPs is decided before later domain
+	// rules, so no direct rule after it can be trusted.
+	onDemand := strings.EqualFold(doc.Routing.DomainStrategy, "IPOnDemand")
+	unresolvedProxy := false
+	for i, rule := range doc.Routing.Rules {
+		outbound, _ := rule["outboundTag"].(string)
+		isDirect := direct[outbound] && rule["balancerTag"] == nil
+		if !appliesTo(rule, ep.Tag) {
+			continue
+		}
+		domains := stringList(rule["domain"])
+		if len(domains) == 0 {
+			if exact(rule) {
+				// A catch-all rule, such as the generated default, decides
+				// every host no earlier rule matched.
+				t.DefaultDirect = isDirect
+				break
+			}
+			if !isDirect {
+				unresolvedProxy = true
+			}
+			continue
+		}
+		if isDirect && (!exact(rule) || (onDemand && unresolvedProxy)) {
+			continue
+		}
+		r := Rule{Index: i, Direct: isDirect}
+		for _, d := range domains {
+			if err := r.add(d, geo); err != nil {
+				return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+			}
+		}
+		r.sort()
+		t.Rules = append(t.Rules, r)
+	}
+	if unresolvedProxy {
+		t.DefaultDirect = false
+	}
+	return t, nil
+}
+
+// appliesTo reports whether rule can match TCP traffic entering through the
+// inbound with tag.
+func appliesTo(rule map[string]any, tag string) bool {
+	if v, ok := rule["inboundTag"]; ok && !containsFold(stringList(v), tag) {
+		return false
+	}
+	if v, ok := rule["network"]; ok {
+		names := stringList(v)
+		if s, isString := v.(string); isString {
+			names = strings.Split(s, ",")
+		}
+		for _, n := range names {
+			if strings.EqualFold(strings.TrimSpace(n), "tcp") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// exact reports whether rule has no conditions besides the ones appliesTo
+// and the domain matchers already cover.
+func exact(rule map[string]any) bool {
+	for key := range rule {
+		switch key {
+		case "type", "outboundTag", "balancerTag", "ruleTag", "domain", "inboundTag", "network":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) add(pattern string, geo *geoSites) error {
+	kind, value, ok := strings.Cut(pattern, ":")
+	if !ok {
+		r.Keywords = append(r.Keywords, strings.ToLower(pattern))
+		return nil
+	}
+	switch kind {
+	case "domain":
+		r.Domains = append(r.Domains, strings.ToLower(value))
+	case "full":
+		r.Full = append(r.Full, strings.ToLower(value))
+	case "keyword":
+		r.Keywords = append(r.Keywords, strings.ToLower(value))
+	case "regexp":
+		r.Regexps = append(r.Regexps, value)
+	case "geosite":
+		return r.addGeoSite(geo, "geosite.dat", value)
+	case "ext", "ext-domain":
+		file, code, ok := strings.Cut(value, ":")
+		if !ok {
+			return fmt.Errorf("invalid ext domain %q", pattern)
+		}
+		return r.addGeoSite(geo, file, code)
+	default:
+		// dotless: only matches plain host names, which are always DIRECT.
+	}
+	return nil
+}
+
+func (r *Rule) addGeoSite(geo *geoSites, file, spec string) error {
+	parts := strings.Split(spec, "@")
+	domains, err := geo.site(file, parts[0])
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		if !d.HasAttrs(parts[1:]) {
+			continue
+		}
+		value := strings.ToLower(d.Value)
+		switch d.Type {
+		case assets.DomainRoot:
+			r.Domains = append(r.Domains, value)
+		case assets.DomainFull:
+			r.Full = append(r.Full, value)
+		case assets.DomainPlain:
+			r.Keywords = append(r.Keywords, value)
+		case assets.DomainRegex:
+			r.Regexps = append(r.Regexps, d.Value)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) sort() {
+	r.Domains = uniqueSorted(r.Domains)
+	r.Full = uniqueSorted(r.Full)
+	r.Keywords = uniqueSorted(r.Keywords)
+	r.Regexps = uniqueSorted(r.Regexps)
+}
+
+// ProxyDirective returns the PAC result for the xray inbound, reached at
+// host.
+func ProxyDirective(ep *sysproxy.InboundEndpoint, host string) string {
+	addr := net.JoinHostPort(host, strconv.Itoa(ep.Port))
+	if ep.Protocol == "socks" {
+		return "SOCKS5 " + addr + "; SOCKS " + addr
+	}
+	return "PROXY " + addr
+}
+
+// Script renders the table as a PAC file whose non-direct result is proxy.
+func (t *Table) Script(proxy string) []byte {
+	type jsRule struct {
+		Direct   bool            `json:"direct"`
+		Domains  map[string]bool `json:"domains"`
+		Full     map[string]bool `json:"full"`
+		Keywords []string        `json:"keywords"`
+		Regexps  []string        `json:"regexps"`
+	}
+	rules := make([]jsRule, 0, len(t.Rules))
+	for _, r := range t.Rules {
+		rules = append(rules, jsRule{
+			Direct:   r.Direct,
+			Domains:  set(r.Domains),
+			Full:     set(r.Full),
+			Keywords: append([]string{}, r.Keywords...),
+			Regexps:  append([]string{}, r.Regexps...),
+		})
+	}
+	bypass := strings.Split(sysproxy.RequiredBypassList, ";")
+	fallback := proxy
+	if t.DefaultDirect {
+		fallback = "DIRECT"
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Generated by v2n-coremesh from the xray routing rules.\n")
+	fmt.Fprintf(&b, "var proxy = %s;\n", jsString(proxy))
+	fmt.Fprintf(&b, "var fallback = %s;\n", jsString(fallback))
+	fmt.Fprintf(&b, "var bypass = %s;\n", jsValue(bypass))
+	fmt.Fprintf(&b, "var rules = %s;\n", jsValue(rules))
+	b.WriteString(scriptBody)
+	return b.Bytes()
+}
+
+const scriptBody = `
+var has = Object.prototype.hasOwnProperty;
+
+function ruleMatches(rule, host) {
+  if (has.call(rule.full, host)) return true;
+  for (var h = host; ; ) {
+    if (has.call(rule.domains, h)) return true;
+    var dot = h.indexOf(".");
+    if (dot < 0) break;
+    h = h.substring(dot + 1);
+  }
+  for (var i = 0; i < rule.keywords.length; i++) {
+    if (host.indexOf(rule.keywords[i]) >= 0) return true;
+  }
+  for (var k = 0; k < rule.regexps.length; k++) {
+    // xray uses RE2; a pattern JavaScript rejects matches proxy rules only,
+    // so the host is still handed to xray.
+    try {
+      if (new RegExp(rule.regexps[k]).test(host)) return true;
+    } catch (e) {
+      if (!rule.direct) return true;
+    }
+  }
+  return false;
+}
+
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  if (isPlainHostName(host)) return "DIRECT";
+  for (var i = 0; i < bypass.length; i++) {
+    if (shExpMatch(host, bypass[i])) return "DIRECT";
+  }
+  for (var j = 0; j < rules.length; j++) {
+    if (ruleMatches(rules[j], host)) return rules[j].direct ? "DIRECT" : proxy;
+  }
+  return fallback;
+}
+`
+
+func jsString(s string) string {
+	return string(jsValue(s))
+}
+
+func jsValue(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// Only strings, bools, slices and maps of them are passed in.
+		panic(err)
+	}
+	return b
+}
+
+func set(items []string) map[string]bool {
+	out := make(map[string]bool, len(items))
+	for _, v := range items {
+		out[v] = true
+	}
+	return out
+}
+
+func uniqueSorted(items []string) []string {
+	sort.Strings(items)
+	out := items[:0]
+	for i, v := range items {
+		if i == 0 || v != items[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// geoSites memoizes decoded geosite categories across rules.
+type geoSites struct {
+	dir   string
+	cache map[string][]assets.GeoDomain
+}
+
+func (g *geoSites) site(file, code string) ([]assets.GeoDomain, error) {
+	key := file + ":" + strings.ToLower(code)
+	if domains, ok := g.cache[key]; ok {
+		return domains, nil
+	}
+	domains, err := assets.ReadGeoSite(filepath.Join(g.dir, file), code)
+	if err != nil {
+		if errors.Is(err, assets.ErrGeoCodeNotFound) {
+			return nil, fmt.Errorf("unknown geosite %q in %s", code, file)
+		}
+		return nil, err
+	}
+	g.cache[key] = domains
+	return domains, nil
+}
@@ -0,0 +1,141 @@
+package pac
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets/geotest"
+)
+
+func writeConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	geotest.WriteSites(t, filepath.Join(dir, "geosite.dat"),
+		geotest.Site{Code: "CN", Domains: []geotest.Domain{
+			{Type: geotest.Root, Value: "cn-site.example"},
+			{Type: geotest.Full, Value: "www.cn-full.example"},
+			{Type: geotest.Plain, Value: "cnkeyword"},
+		}},
+		geotest.Site{Code: "ADS", Domains: []geotest.Domain{{Type: geotest.Plain, Value: "adserver"}}},
+	)
+	path := filepath.Join(dir, "xray.generated.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `{
+  "inbounds": [{"tag": "http-in", "protocol": "http", "listen": "127.0.0.1", "port": 10809}],
+  "outbounds": [
+    {"tag": "proxy", "protocol": "vless"},
+    {"tag": "direct", "protocol": "freedom"},
+    {"tag": "block", "protocol": "blackhole"}
+  ],
+  "routing": {"rules": [
+    {"type": "field", "domain": ["domain:Corp.Example", "full:intranet.example", "regexp:^git\\."], "outboundTag": "direct"},
+    {"type": "field", "domain": ["geosite:ads"], "outboundTag": "block"},
+    {"type": "field", "domain": ["domain:ports.example"], "port": "22", "outboundTag": "direct"},
+    {"type": "field", "domain": ["domain:api.example"], "inboundTag": ["api"], "outboundTag": "direct"},
+    {"type": "field", "domain": ["domain:udp.example"], "network": "udp", "outboundTag": "direct"},
+    {"type": "field", "domain": ["domain:lb.example"], "balancerTag": "auto"},
+    {"type": "field", "domain": ["geosite:cn"], "outboundTag": "direct"}
+  ]}
+}`)
+
+	table, err := Load(path, dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := []Rule{
+		{Index: 0, Direct: true, Domains: []string{"corp.example"}, Full: []string{"intranet.example"}, Regexps: []string{`^git\.`}},
+		{Index: 1, Keywords: []string{"adserver"}},
+		{Index: 5, Domains: []string{"lb.example"}},
+		{Index: 6, Direct: true, Domains: []string{"cn-site.example"}, Full: []string{"www.cn-full.example"}, Keywords: []string{"cnkeyword"}},
+	}
+	if len(table.Rules) != len(want) {
+		t.Fatalf("got %d rules, want %d: %#v", len(table.Rules), len(want), table.Rules)
+	}
+	for i := range want {
+		got := table.Rules[i]
+		if got.Index != want[i].Index || got.Direct != want[i].Direct ||
+			strings.Join(got.Domains, ",") != strings.Join(want[i].Domains, ",") ||
+			strings.Join(got.Full, ",") != strings.Join(want[i].Full, ",") ||
+			strings.Join(got.Keywords, ",") != strings.Join(want[i].Keywords, ",") ||
+			strings.Join(got.Regexps, ",") != strings.Join(want[i].Regexps, ",") {
+			t.Fatalf("rule %d: got %#v, want %#v", i, got, want[i])
+		}
+	}
+	if table.DefaultDirect {
+		t.Fatal("default should go through the proxy")
+	}
+
+	script := string(table.Script(ProxyDirective(table.Inbound, "127.0.0.1")))
+	for _, s := range []string{
+		`var proxy = "PROXY 127.0.0.1:10809";`,
+		`var fallback = "PROXY 127.0.0.1:10809";`,
+		`"corp.example":true`,
+		"function FindProxyForURL(url, host)",
+	} {
+		if !strings.Contains(script, s) {
+			t.Fatalf("script is missing %q:\n%s", s, script)
+		}
+	}
+}
+
+func TestLoadDefaultDirect(t *testing.T) {
+	dir := t.TempDir()
+	config := `{
+  "inbounds": [{"tag": "socks-in", "protocol": "socks", "listen": "0.0.0.0", "port": 10808}],
+  "outbounds": [{"tag": "direct", "protocol": "freedom"}, {"tag": "proxy", "protocol": "vless"}],
+  "routing": {"domainStrategy": "IPOnDemand", "rules": [
+    {"type": "field", "domain": ["domain:blocked.example"], "outboundTag": "proxy"}%s,
+    {"type": "field", "domain": ["domain:corp.example"], "outboundTag": "direct"}
+  ]}
+}`
+	table, err := Load(writeConfig(t, dir, strings.Replace(config, "%s", "", 1)), dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !table.DefaultDirect || len(table.Rules) != 2 {
+		t.Fatalf("unexpected table: %#v", table)
+	}
+	if got := ProxyDirective(table.Inbound, "192.168.1.2"); got != "SOCKS5 192.168.1.2:10808; SOCKS 192.168.1.2:10808" {
+		t.Fatalf("unexpected directive %q", got)
+	}
+
+	// An IP rule that is resolved on demand shadows later direct rules and
+	// the direct default.
+	ipRule := `,
+    {"type": "field", "ip": ["203.0.113.0/24"], "outboundTag": "proxy"}`
+	table, err = Load(writeConfig(t, dir, strings.Replace(config, "%s", ipRule, 1)), dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if table.DefaultDirect || len(table.Rules) != 1 || table.Rules[0].Direct {
+		t.Fatalf("unexpected table: %#v", table)
+	}
+}
+
+func TestLoadCatchAll(t *testing.T) {
+	dir := t.TempDir()
+	config := `{
+  "inbounds": [{"tag": "http-in", "protocol": "http", "listen": "127.0.0.1", "port": 10809}],
+  "outbounds": [{"tag": "proxy", "protocol": "vless"}, {"tag": "direct", "protocol": "freedom"}],
+  "routing": {"rules": [
+    {"type": "field", "domain": ["domain:blocked.example"], "outboundTag": "proxy"},
+    {"type": "field", "network": "tcp,udp", "outboundTag": "direct"},
+    {"type": "field", "domain": ["domain:unreachable.example"], "outboundTag": "proxy"}
+  ]}
+}`
+	table, err := Load(writeConfig(t, dir, config), dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !table.DefaultDirect || len(table.Rules) != 1 || table.Rules[0].Direct {
+		t.Fatalf("catch-all rule should end the table with a direct default: %#v", table)
+	}
+}
@@ -0,0 +1,530 @@
+// Package routesim evaluates a generated xray routing table offline and
+// explains which rule a destination hits.
+package routesim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets"
+)
+
+const defaultPort = 443
+
+// Rule origins, in the order xraygen writes them.
+const (
+	OriginCoreInbound = "core inbound"
+	OriginCustom      = "custom"
+	OriginBase        = "base"
+	OriginFallback    = "default"
+)
+
+// Origins tells how many leading rules pin the per-core and via inbounds
+// and came from custom_rules.yaml and from the imported routing, and whether
+// the last rule is the generated catch-all, so each rule can be attributed to
+// its source.
+type Origins struct {
+	CoreInbounds  int
+	Custom        int
+	Imported      int
+	ImportedLabel string
+	Fallback      bool
+}
+
+func (o Origins) of(i int) string {
+	if i < o.CoreInbounds {
+		return OriginCoreInbound
+	}
+	i -= o.CoreInbounds
+	switch {
+	case i < o.Custom:
+		return OriginCustom
+	case i < o.Custom+o.Imported:
+		if o.ImportedLabel == "" {
+			return "imported"
+		}
+		return o.ImportedLabel
+	default:
+		return OriginBase
+	}
+}
+
+// Target is the destination being routed.
+type Target struct {
+	Domain     string
+	IP         netip.Addr
+	Port       int
+	Network    string
+	InboundTag string
+}
+
+func (t Target) String() string {
+	host := t.Domain
+	if host == "" {
+		host = t.IP.String()
+	}
+	return fmt.Sprintf("%s (%s)", net.JoinHostPort(host, strconv.Itoa(t.Port)), t.Network)
+}
+
+// ParseTarget parses "domain", "ip", "domain:port", "ip:port" or "[ipv6]:port".
+// The port defaults to 443 and the network to tcp.
+func ParseTarget(s string) (Target, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Target{}, fmt.Errorf("target is empty")
+	}
+	t := Target{Port: defaultPort, Network: "tcp"}
+	host := s
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return Target{}, fmt.Errorf("invalid port %q", p)
+		}
+		host, t.Port = h, port
+	}
+	host = strings.Trim(host, "[]")
+	if ip, err := netip.ParseAddr(host); err == nil {
+		t.IP = ip.Unmap()
+		return t, nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || strings.ContainsAny(host, " /:") {
+		return Target{}, fmt.Errorf("invalid target %q", s)
+	}
+	t.Domain = host
+	return t, nil
+}
+
+// Result explains the routing decision for one target.
+type Result struct {
+	// Index is the zero-based rule index, or -1 when no rule matched.
+	Index       int
+	Origin      string
+	Rule        map[string]any
+	OutboundTag string
+	BalancerTag string
+	// Unevaluated lists rules skipped because they need runtime context
+	// (source, user, protocol sniffing, process) that is not available offline.
+	Unevaluated []int
+	// NeedsResolve is set when the domain strategy would resolve the domain
+	// and retry IP rules, which an offline run cannot do.
+	NeedsResolve bool
+}
+
+// Simulator holds a parsed routing table.
+type Simulator struct {
+	rules           []map[string]any
+	origins         Origins
+	domainStrategy  string
+	defaultOutbound string
+	geo             *geoCache
+}
+
+// Load reads a generated xray config. Geo references resolve against .dat
+// files in assetDir.
+func Load(xrayConfig, assetDir string, origins Origins) (*Simulator, error) {
+	b, err := os.ReadFile(xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read xray config: %w", err)
+	}
+	var doc struct {
+		Outbounds []struct {
+			Tag string `json:"tag"`
+		} `json:"outbounds"`
+		Routing struct {
+			DomainStrategy string           `json:"domainStrategy"`
+			Rules          []map[string]any `json:"rules"`
+		} `json:"routing"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+	s := &Simulator{
+		rules:          doc.Routing.Rules,
+		origins:        origins,
+		domainStrategy: doc.Routing.DomainStrategy,
+		geo:            newGeoCache(assetDir),
+	}
+	if len(doc.Outbounds) > 0 {
+		s.defaultOutbound = doc.Outbounds[0].Tag
+	}
+	return s, nil
+}
+
+// DefaultOutbound is the outbound xray uses when no rule matches.
+func (s *Simulator) DefaultOutbound() string {
+	return s.defaultOutbound
+}
+
+// Route evaluates the rules in order and returns the first match.
+func (s *Simulator) Route(t Target) (*Result, error) {
+	res := &Result{Index: -1}
+	for i, rule := range s.rules {
+		ok, complete, err := s.matchRule(rule, t)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+		if !complete {
+			res.Unevaluated = append(res.Unevaluated, i)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		res.Index = i
+		res.Origin = s.origins.of(i)
+		if s.origins.Fallback && i == len(s.rules)-1 {
+			res.Origin = OriginFallback
+		}
+		res.Rule = rule
+		res.OutboundTag, _ = rule["outboundTag"].(string)
+		res.BalancerTag, _ = rule["balancerTag"].(string)
+		return res, nil
+	}
+	if t.Domain != "" && s.hasIPRules() {
+		switch strings.ToLower(s.domainStrategy) {
+		case "ipifnonmatch", "ipondemand":
+			res.NeedsResolve = true
+		}
+	}
+	return res, nil
+}
+
+func (s *Simulator) hasIPRules() bool {
+	for _, rule := range s.rules {
+		if _, ok := rule["ip"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRule reports whether every condition of rule matches t. complete is
+// false when no evaluated condition failed but the rule also has conditions
+// that cannot be checked offline.
+func (s *Simulator) matchRule(rule map[string]any, t Target) (ok, complete bool, err error) {
+	if value, has := rule["domain"]; has {
+		if t.Domain == "" {
+			return false, true, nil
+		}
+		if matched, err := s.matchDomain(stringList(value), t.Domain); err != nil || !matched {
+			return false, true, err
+		}
+	}
+	if value, has := rule["ip"]; has {
+		if !t.IP.IsValid() {
+			return false, true, nil
+		}
+		if matched, err := s.matchIP(stringList(value), t.IP); err != nil || !matched {
+			return false, true, err
+		}
+	}
+	if value, has := rule["port"]; has {
+		if matched, err := matchPort(value, t.Port); err != nil || !matched {
+			return false, true, err
+		}
+	}
+	if value, has := rule["network"]; has && !matchNetwork(value, t.Network) {
+		return false, true, nil
+	}
+	if value, has := rule["inboundTag"]; has && t.InboundTag != "" && !containsFold(stringList(value), t.InboundTag) {
+		return false, true, nil
+	}
+	for key := range rule {
+		switch key {
+		case "type", "outboundTag", "balancerTag", "ruleTag", "domain", "ip", "port", "network":
+		case "inboundTag":
+			if t.InboundTag == "" {
+				return false, false, nil
+			}
+		default:
+			return false, false, nil
+		}
+	}
+	return true, true, nil
+}
+
+func (s *Simulator) matchDomain(patterns []string, domain string) (bool, error) {
+	for _, p := range patterns {
+		matched, err := s.matchDomainPattern(p, domain)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (s *Simulator) matchDomainPattern(p, domain string) (bool, error) {
+	kind, value, ok := strings.Cut(p, ":")
+	if !ok {
+		return strings.Contains(domain, strings.ToLower(p)), nil
+	}
+	switch kind {
+	case "domain":
+		return matchRootDomain(domain, strings.ToLower(value)), nil
+	case "full":
+		return domain == strings.ToLower(value), nil
+	case "keyword":
+		return strings.Contains(domain, strings.ToLower(value)), nil
+	case "regexp":
+		re, err := s.geo.regexp(value)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(domain), nil
+	case "dotless":
+		return !strings.Contains(domain, ".") && strings.Contains(domain, value), nil
+	case "geosite":
+		return s.matchGeoSite("geosite.dat", value, domain)
+	case "ext", "ext-domain":
+		file, code, ok := strings.Cut(value, ":")
+		if !ok {
+			return false, fmt.Errorf("invalid ext domain %q", p)
+		}
+		return s.matchGeoSite(file, code, domain)
+	default:
+		return strings.Contains(domain, strings.ToLower(p)), nil
+	}
+}
+
+func (s *Simulator) matchGeoSite(file, spec, domain string) (bool, error) {
+	parts := strings.Split(spec, "@")
+	domains, err := s.geo.site(file, parts[0])
+	if err != nil {
+		return false, err
+	}
+	for _, d := range domains {
+		if !d.HasAttrs(parts[1:]) {
+			continue
+		}
+		value := strings.ToLower(d.Value)
+		switch d.Type {
+		case assets.DomainPlain:
+			if strings.Contains(domain, value) {
+				return true, nil
+			}
+		case assets.DomainRoot:
+			if matchRootDomain(domain, value) {
+				return true, nil
+			}
+		case assets.DomainFull:
+			if domain == value {
+				return true, nil
+			}
+		case assets.DomainRegex:
+			re, err := s.geo.regexp(d.Value)
+			if err != nil {
+				return false, err
+			}
+			if re.MatchString(domain) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func matchRootDomain(domain, root string) bool {
+	return domain == root || strings.HasSuffix(domain, "."+root)
+}
+
+func (s *Simulator) matchIP(patterns []string, ip netip.Addr) (bool, error) {
+	for _, p := range patterns {
+		matched, err := s.matchIPPattern(p, ip)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (s *Simulator) matchIPPattern(p string, ip netip.Addr) (bool, error) {
+	switch {
+	case strings.HasPrefix(p, "geoip:"):
+		return s.matchGeoIP("geoip.dat", strings.TrimPrefix(p, "geoip:"), ip)
+	case strings.HasPrefix(p, "ext:"), strings.HasPrefix(p, "ext-ip:"):
+		_, rest, _ := strings.Cut(p, ":")
+		file, code, ok := strings.Cut(rest, ":")
+		if !ok {
+			return false, fmt.Errorf("invalid ext ip %q", p)
+		}
+		return s.matchGeoIP(file, code, ip)
+	}
+	if strings.Contains(p, "/") {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return false, fmt.Errorf("invalid cidr %q: %w", p, err)
+		}
+		return prefix.Contains(ip), nil
+	}
+	addr, err := netip.ParseAddr(p)
+	if err != nil {
+		return false, fmt.Errorf("invalid ip %q: %w", p, err)
+	}
+	return addr.Unmap() == ip, nil
+}
+
+func (s *Simulator) matchGeoIP(file, code string, ip netip.Addr) (bool, error) {
+	reverse := strings.HasPrefix(code, "!")
+	geo, err := s.geo.ip(file, strings.TrimPrefix(code, "!"))
+	if err != nil {
+		return false, err
+	}
+	contained := false
+	for _, prefix := range geo.CIDRs {
+		if prefix.Contains(ip) {
+			contained = true
+			break
+		}
+	}
+	return contained != (reverse != geo.ReverseMatch), nil
+}
+
+func matchPort(value any, port int) (bool, error) {
+	var spec string
+	switch v := value.(type) {
+	case float64:
+		return int(v) == port, nil
+	case int:
+		return v == port, nil
+	case string:
+		spec = v
+	default:
+		return false, fmt.Errorf("unsupported port value %v", value)
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return false, fmt.Errorf("invalid port %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return false, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		if port >= from && port <= to {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchNetwork(value any, network string) bool {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = strings.Split(v, ",")
+	default:
+		names = stringList(v)
+	}
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSpace(n), network) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// geoCache memoizes decoded categories and compiled regexps; each geo lookup
+// would otherwise re-read the whole .dat file.
+type geoCache struct {
+	dir     string
+	sites   map[string][]assets.GeoDomain
+	ips     map[string]*assets.GeoIP
+	regexps map[string]*regexp.Regexp
+}
+
+func newGeoCache(dir string) *geoCache {
+	return &geoCache{
+		dir:     dir,
+		sites:   map[string][]assets.GeoDomain{},
+		ips:     map[string]*assets.GeoIP{},
+		regexps: map[string]*regexp.Regexp{},
+	}
+}
+
+func (c *geoCache) site(file, code string) ([]assets.GeoDomain, error) {
+	key := file + ":" + strings.ToLower(code)
+	if domains, ok := c.sites[key]; ok {
+		return domains, nil
+	}
+	domains, err := assets.ReadGeoSite(filepath.Join(c.dir, file), code)
+	if err != nil {
+		if errors.Is(err, assets.ErrGeoCodeNotFound) {
+			return nil, fmt.Errorf("unknown geosite %q in %s", code, file)
+		}
+		return nil, err
+	}
+	c.sites[key] = domains
+	return domains, nil
+}
+
+func (c *geoCache) ip(file, code string) (*assets.GeoIP, error) {
+	key := file + ":" + strings.ToLower(code)
+	if geo, ok := c.ips[key]; ok {
+		return geo, nil
+	}
+	geo, err := assets.ReadGeoIP(filepath.Join(c.dir, file), code)
+	if err != nil {
+		if errors.Is(err, assets.ErrGeoCodeNotFound) {
+			return nil, fmt.Errorf("unknown geoip %q in %s", code, file)
+		}
+		return nil, err
+	}
+	c.ips[key] = geo
+	return geo, nil
+}
+
+func (c *geoCache) regexp(expr string) (*regexp.Regexp, error) {
+	if re, ok := c.regexps[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp %q: %w", expr, err)
+	}
+	c.regexps[expr] = re
+	return re, nil
+}
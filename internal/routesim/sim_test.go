@@ -0,0 +1,143 @@
+package routesim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets/geotest"
+)
+
+func newTestSimulator(t *testing.T, rules string) *Simulator {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"geosite.dat": geotest.SiteList(geotest.Site{Code: "OPENAI", Domains: []geotest.Domain{
+			{Type: geotest.Root, Value: "openai.com"},
+			{Type: geotest.Full, Value: "tracker.openai.com", Attrs: []string{"ads"}},
+		}}),
+		"geoip.dat": geotest.IPList(geotest.IP{Code: "PRIVATE", CIDRs: []string{"192.168.0.0/16"}}),
+		"xray.json": []byte(`{
+  "outbounds": [{"tag": "proxy"}, {"tag": "direct"}],
+  "routing": {"domainStrategy": "IPIfNonMatch", "rules": ` + rules + `}
+}`),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim, err := Load(filepath.Join(dir, "xray.json"), dir, Origins{Custom: 1, Imported: 3, ImportedLabel: "v2rayN"})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return sim
+}
+
+func TestRouteFollowsRuleOrder(t *testing.T) {
+	sim := newTestSimulator(t, `[
+  {"type": "field", "domain": ["geosite:openai@ads"], "outboundTag": "block"},
+  {"type": "field", "protocol": ["bittorrent"], "outboundTag": "direct"},
+  {"type": "field", "domain": ["geosite:openai"], "port": "443,8443", "outboundTag": "naive-a"},
+  {"type": "field", "ip": ["geoip:private", "10.0.0.0/8"], "outboundTag": "direct"},
+  {"type": "field", "domain": ["regexp:^api\\.", "keyword:example"], "network": "udp", "outboundTag": "direct"},
+  {"type": "field", "port": "1000-2000", "outboundTag": "direct"}
+]`)
+
+	cases := []struct {
+		target   string
+		index    int
+		origin   string
+		outbound string
+	}{
+		{"tracker.openai.com", 0, OriginCustom, "block"},
+		{"api.openai.com", 2, "v2rayN", "naive-a"},
+		{"api.openai.com:80", -1, "", ""},
+		{"192.168.1.10", 3, "v2rayN", "direct"},
+		{"10.1.2.3:22", 3, "v2rayN", "direct"},
+		{"www.example.org:1500", 5, OriginBase, "direct"},
+	}
+	for _, tc := range cases {
+		target, err := ParseTarget(tc.target)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.target, err)
+		}
+		res, err := sim.Route(target)
+		if err != nil {
+			t.Fatalf("route %s: %v", tc.target, err)
+		}
+		if res.Index != tc.index || res.Origin != tc.origin || res.OutboundTag != tc.outbound {
+			t.Fatalf("route %s: got #%d %s %s", tc.target, res.Index, res.Origin, res.OutboundTag)
+		}
+		if tc.index != 0 && (len(res.Unevaluated) != 1 || res.Unevaluated[0] != 1) {
+			t.Fatalf("route %s: protocol rule should be unevaluated, got %v", tc.target, res.Unevaluated)
+		}
+	}
+
+	target, _ := ParseTarget("api.example.net")
+	target.Network = "udp"
+	res, err := sim.Route(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Index != 4 {
+		t.Fatalf("udp keyword rule should match, got #%d", res.Index)
+	}
+
+	target, _ = ParseTarget("unmatched.test")
+	res, err = sim.Route(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Index != -1 || !res.NeedsResolve || sim.DefaultOutbound() != "proxy" {
+		t.Fatalf("unexpected no-match result: %#v", res)
+	}
+}
+
+func TestRouteReportsUnknownGeoCode(t *testing.T) {
+	sim := newTestSimulator(t, `[{"type": "field", "domain": ["geosite:anthropics"], "outboundTag": "proxy"}]`)
+	target, _ := ParseTarget("claude.ai")
+	if _, err := sim.Route(target); err == nil {
+		t.Fatal("expected unknown geosite error")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		in     string
+		domain string
+		ip     string
+		port   int
+	}{
+		{"API.OpenAI.com", "api.openai.com", "", 443},
+		{"example.com:8080", "example.com", "", 8080},
+		{"1.1.1.1", "", "1.1.1.1", 443},
+		{"[2001:db8::1]:53", "", "2001:db8::1", 53},
+		{"2001:db8::1", "", "2001:db8::1", 443},
+	}
+	for _, tc := range cases {
+		got, err := ParseTarget(tc.in)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.in, err)
+		}
+		ip := ""
+		if got.IP.IsValid() {
+			ip = got.IP.String()
+		}
+		if got.Domain != tc.domain || ip != tc.ip || got.Port != tc.port {
+			t.Fatalf("parse %s: %#v", tc.in, got)
+		}
+	}
+	if _, err := ParseTarget("example.com:99999"); err == nil {
+		t.Fatal("expected invalid port error")
+	}
+}
+
+func TestOriginsWithCoreInbounds(t *testing.T) {
+	o := Origins{CoreInbounds: 2, Custom: 1, Imported: 1, ImportedLabel: "v2rayN"}
+	for i, want := range []string{OriginCoreInbound, OriginCoreInbound, OriginCustom, "v2rayN", OriginBase} {
+		if got := o.of(i); got != want {
+			t.Fatalf("rule #%d: origin %q, want %q", i, got, want)
+		}
+	}
+}
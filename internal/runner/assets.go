@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
+)
+
+// assetRefreshLoop runs the deferred startup refresh and then checks the
+// assets every RefreshInterval until done is closed. Running both from one
+// goroutine keeps refreshes from overlapping.
+func (s *session) assetRefreshLoop(done <-chan struct{}) {
+	if s.opts.RefreshAssetsOnStart {
+		s.refreshAssetsViaMesh()
+	}
+	if s.opts.RefreshInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.refreshAssetsViaMesh()
+		}
+	}
+}
+
+// refreshAssetsViaMesh runs Options.RefreshAssets through xray's local
+// inbound (or directly when xray has no usable inbound) and restarts xray
+// when any asset changed. Cores keep running.
+func (s *session) refreshAssetsViaMesh() {
+	s.mu.Lock()
+	xrayConfig := s.cfg.App.GeneratedXrayConfig
+	s.mu.Unlock()
+
+	route := "via mesh"
+	proxy, err := meshProxyURL(xrayConfig)
+	if err != nil {
+		s.logf("[assets] no usable xray inbound, refreshing directly: %v", err)
+		proxy = nil
+		route = "directly"
+	}
+	changed, err := s.opts.RefreshAssets(proxy)
+	if err != nil {
+		s.logf("[assets] refresh %s failed: %v", route, err)
+		return
+	}
+	if !changed {
+		s.logf("[assets] no asset changed")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sup.restart(xrayProcessKey); err != nil {
+		s.logf("[assets] restart xray failed: %v", err)
+		return
+	}
+	s.logf("[assets] assets updated, xray restarted")
+	s.refreshPAC(s.cfg)
+}
+
+// meshProxyURL returns a proxy URL for the first usable xray inbound.
+func meshProxyURL(xrayConfig string) (*url.URL, error) {
+	ep, err := sysproxy.DetectProxyEndpoint(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	host := net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port))
+	var user *url.Userinfo
+	if ep.Username != "" {
+		user = url.UserPassword(ep.Username, ep.Password)
+	}
+	switch ep.Protocol {
+	case "http":
+		return &url.URL{Scheme: "http", User: user, Host: host}, nil
+	case "socks", "mixed":
+		return &url.URL{Scheme: "socks5", User: user, Host: host}, nil
+	default:
+		return nil, fmt.Errorf("inbound protocol %q cannot be used as a proxy", ep.Protocol)
+	}
+}
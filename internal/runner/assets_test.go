@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestMeshProxyURL(t *testing.T) {
+	cases := []struct {
+		inbounds string
+		want     string
+	}{
+		{`[{"protocol": "socks", "listen": "127.0.0.1", "port": 10808}, {"protocol": "http", "listen": "0.0.0.0", "port": 10809}]`, "http://127.0.0.1:10809"},
+		{`[{"protocol": "socks", "listen": "::1", "port": 10808}]`, "socks5://[::1]:10808"},
+		{`[{"protocol": "mixed", "listen": "192.168.1.20", "port": 10808, "settings": {"auth": "password", "accounts": [{"user": "u", "pass": "p"}]}}]`, "socks5://u:p@192.168.1.20:10808"},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "xray.json")
+		if err := os.WriteFile(path, []byte(`{"inbounds": `+tc.inbounds+`}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := meshProxyURL(path)
+		if err != nil {
+			t.Fatalf("mesh proxy url: %v", err)
+		}
+		if got.String() != tc.want {
+			t.Fatalf("got %s, want %s", got, tc.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "xray.json")
+	if err := os.WriteFile(path, []byte(`{"inbounds": [{"protocol": "dokodemo-door", "port": 53}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := meshProxyURL(path); err == nil {
+		t.Fatal("dokodemo-door inbound should not be usable as proxy")
+	}
+}
+
+func TestAssetRefreshRestartsOnlyXray(t *testing.T) {
+	sleepBin, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+	xrayConfig := filepath.Join(t.TempDir(), "xray.json")
+	if err := os.WriteFile(xrayConfig, []byte(`{"inbounds": [{"protocol": "socks", "listen": "127.0.0.1", "port": 10808}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var proxies []string
+	changed := []bool{false, true}
+	s := &session{
+		logf: t.Logf,
+		sup:  newSupervisor(t.Logf),
+		cfg:  &config.File{App: config.App{GeneratedXrayConfig: xrayConfig}},
+		opts: Options{
+			RefreshAssets: func(proxy *url.URL) (bool, error) {
+				proxies = append(proxies, proxy.String())
+				next := changed[0]
+				changed = changed[1:]
+				return next, nil
+			},
+		},
+	}
+	defer s.sup.stopAll()
+	for _, key := range []string{"core-a", xrayProcessKey} {
+		if _, err := s.sup.launch(processSpec{key: key, name: key, bin: sleepBin, args: []string{"30"}}, nil); err != nil {
+			t.Fatalf("launch %s: %v", key, err)
+		}
+	}
+	before := pidsByName(s.sup)
+
+	s.refreshAssetsViaMesh()
+	if got := pidsByName(s.sup); got["xray"] != before["xray"] {
+		t.Fatal("xray must not restart when no asset changed")
+	}
+
+	s.refreshAssetsViaMesh()
+	deadline := time.Now().Add(5 * time.Second)
+	after := pidsByName(s.sup)
+	for (after["xray"] == 0 || after["xray"] == before["xray"]) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		after = pidsByName(s.sup)
+	}
+	if after["xray"] == 0 || after["xray"] == before["xray"] {
+		t.Fatal("xray should restart after assets changed")
+	}
+	if after["core-a"] != before["core-a"] {
+		t.Fatal("cores must keep running")
+	}
+	if len(proxies) != 2 || proxies[0] != "socks5://127.0.0.1:10808" {
+		t.Fatalf("unexpected proxies: %#v", proxies)
+	}
+}
+
+func pidsByName(sup *supervisor) map[string]int {
+	out := map[string]int{}
+	for _, p := range sup.status(time.Now()) {
+		out[p.Name] = p.PID
+	}
+	return out
+}
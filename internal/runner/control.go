@@ -0,0 +1,207 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
+)
+
+const (
+	ControlFileName   = "coremesh.control"
+	controlSocketName = "coremesh.sock"
+)
+
+// ControlEndpoint is written to <conf-dir>/coremesh.control while a run
+// session is serving its control API.
+type ControlEndpoint struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	PID     int    `json:"pid"`
+}
+
+// Status is the response of the status endpoint.
+type Status struct {
+	StartedAt time.Time       `json:"started_at"`
+	Processes []ProcessStatus `json:"processes"`
+	// XrayConfig is the config xray runs with, such as the bind-mode copy.
+	XrayConfig string `json:"xray_config"`
+}
+
+type controlServer struct {
+	endpoint  string
+	server    *http.Server
+	filePath  string
+	cleanupFn func()
+}
+
+func ControlFilePath(confDir string) string {
+	return filepath.Join(confDir, ControlFileName)
+}
+
+// processAlive is replaced in tests.
+var processAlive = sysproxy.ProcessAlive
+
+// checkNoLiveSession fails when the control file in confDir belongs to a
+// session that is still running, so a second run cannot take over its
+// control endpoint. A stale file or socket is left for the caller to replace.
+func checkNoLiveSession(confDir string) error {
+	b, err := os.ReadFile(ControlFilePath(confDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read control endpoint file: %w", err)
+	}
+	var ep ControlEndpoint
+	if err := json.Unmarshal(b, &ep); err != nil {
+		return nil
+	}
+	if ep.PID != os.Getpid() && processAlive(ep.PID) {
+		return fmt.Errorf("another session (pid %d) is running in %s", ep.PID, confDir)
+	}
+	if ep.Network != "" && ep.Address != "" {
+		if conn, err := net.DialTimeout(ep.Network, ep.Address, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("another session is serving %s:%s for %s", ep.Network, ep.Address, confDir)
+		}
+	}
+	return nil
+}
+
+func startControlServer(s *session, confDir string) (*controlServer, error) {
+	if err := checkNoLiveSession(confDir); err != nil {
+		return nil, err
+	}
+	token, err := newControlToken()
+	if err != nil {
+		return nil, err
+	}
+	ln, network, cleanupFn, err := listenControl(confDir)
+	if err != nil {
+		return nil, err
+	}
+	ep := ControlEndpoint{
+		Network: network,
+		Address: ln.Addr().String(),
+		Token:   token,
+		PID:     os.Getpid(),
+	}
+	content, err := json.MarshalIndent(ep, "", "  ")
+	if err != nil {
+		ln.Close()
+		cleanupFn()
+		return nil, fmt.Errorf("marshal control endpoint: %w", err)
+	}
+	filePath := ControlFilePath(confDir)
+	if err := os.WriteFile(filePath, content, 0o600); err != nil {
+		ln.Close()
+		cleanupFn()
+		return nil, fmt.Errorf("write control endpoint file: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           controlHandler(s, token),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logf("[control] serve failed: %v", err)
+		}
+	}()
+	return &controlServer{
+		endpoint:  network + ":" + ep.Address,
+		server:    srv,
+		filePath:  filePath,
+		cleanupFn: cleanupFn,
+	}, nil
+}
+
+func (c *controlServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := c.server.Shutdown(ctx)
+	_ = os.Remove(c.filePath)
+	c.cleanupFn()
+	return err
+}
+
+func controlHandler(s *session, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		st := Status{
+			StartedAt: s.startedAt,
+			Processes: s.sup.status(time.Now()),
+		}
+		s.mu.Lock()
+		if s.cfg != nil {
+			st.XrayConfig = s.cfg.App.GeneratedXrayConfig
+		}
+		s.mu.Unlock()
+		writeControlJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("POST /v1/restart/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := s.restart(name); err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeControlJSON(w, http.StatusOK, map[string]string{"result": "restarting " + name})
+	})
+	mux.HandleFunc("POST /v1/reload", func(w http.ResponseWriter, r *http.Request) {
+		s.logf("[control] reload requested")
+		if err := s.reload(); err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlJSON(w, http.StatusOK, map[string]string{"result": "reloaded"})
+	})
+	mux.HandleFunc("POST /v1/stop", func(w http.ResponseWriter, r *http.Request) {
+		s.logf("[control] stop requested")
+		writeControlJSON(w, http.StatusOK, map[string]string{"result": "stopping"})
+		s.stop()
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			writeControlError(w, http.StatusUnauthorized, fmt.Errorf("invalid control token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeControlJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeControlError(w http.ResponseWriter, status int, err error) {
+	writeControlJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func newControlToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate control token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func listenLoopback() (net.Listener, string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("listen control endpoint: %w", err)
+	}
+	return ln, "tcp", func() {}, nil
+}
@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ControlClient talks to the control endpoint of a running session.
+type ControlClient struct {
+	endpoint ControlEndpoint
+	http     *http.Client
+}
+
+func NewControlClient(confDir string) (*ControlClient, error) {
+	b, err := os.ReadFile(ControlFilePath(confDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no running session found in %s", confDir)
+		}
+		return nil, fmt.Errorf("read control endpoint file: %w", err)
+	}
+	var ep ControlEndpoint
+	if err := json.Unmarshal(b, &ep); err != nil {
+		return nil, fmt.Errorf("parse control endpoint file: %w", err)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, ep.Network, ep.Address)
+		},
+	}
+	return &ControlClient{
+		endpoint: ep,
+		http:     &http.Client{Transport: transport, Timeout: 2 * time.Minute},
+	}, nil
+}
+
+func (c *ControlClient) Status() (*Status, error) {
+	var st Status
+	if err := c.do(http.MethodGet, "/v1/status", &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *ControlClient) Restart(name string) error {
+	return c.do(http.MethodPost, "/v1/restart/"+url.PathEscape(name), nil)
+}
+
+func (c *ControlClient) Reload() error {
+	return c.do(http.MethodPost, "/v1/reload", nil)
+}
+
+func (c *ControlClient) Stop() error {
+	return c.do(http.MethodPost, "/v1/stop", nil)
+}
+
+func (c *ControlClient) do(method, path string, out any) error {
+	req, err := http.NewRequest(method, "http://coremesh"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.endpoint.Token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("contact running session: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read control response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("unexpected control status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("parse control response: %w", err)
+	}
+	return nil
+}
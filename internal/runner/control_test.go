@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestControlClientRoundTrip(t *testing.T) {
+	sleepBin, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+	tmp := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &session{
+		logf:      t.Logf,
+		sup:       newSupervisor(t.Logf),
+		startedAt: time.Now(),
+		stop:      cancel,
+		cfg:       &config.File{App: config.App{GeneratedXrayConfig: "/conf/runtime_bind_all/xray.generated.bindall.json"}},
+	}
+	defer s.sup.stopAll()
+	if _, err := s.sup.launch(processSpec{
+		key:    "core-a",
+		name:   "core core-a",
+		bin:    sleepBin,
+		args:   []string{"30"},
+		policy: restartPolicy{mode: RestartOnFailure, backoff: time.Millisecond, maxBackoff: time.Millisecond},
+	}, nil); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+
+	ctl, err := startControlServer(s, tmp)
+	if err != nil {
+		t.Fatalf("start control server: %v", err)
+	}
+	defer ctl.Close()
+
+	client, err := NewControlClient(tmp)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	st, err := client.Status()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(st.Processes) != 1 || st.Processes[0].Name != "core-a" || st.Processes[0].PID <= 0 ||
+		st.XrayConfig != s.cfg.App.GeneratedXrayConfig {
+		t.Fatalf("unexpected status: %#v", st)
+	}
+
+	if err := client.Restart("missing"); err == nil {
+		t.Fatal("expected error for unknown process")
+	}
+	if err := client.Restart("core-a"); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err = client.Status()
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		if st.Processes[0].Restarts == 1 && st.Processes[0].State == stateRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process was not restarted: %#v", st.Processes[0])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := client.Reload(); err == nil {
+		t.Fatal("expected reload error without a reload function")
+	}
+	if err := client.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stop did not cancel the session")
+	}
+}
+
+func TestControlRejectsWrongToken(t *testing.T) {
+	tmp := t.TempDir()
+	s := &session{logf: t.Logf, sup: newSupervisor(t.Logf), stop: func() {}}
+	ctl, err := startControlServer(s, tmp)
+	if err != nil {
+		t.Fatalf("start control server: %v", err)
+	}
+	defer ctl.Close()
+
+	client, err := NewControlClient(tmp)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	client.endpoint.Token = "wrong"
+	if _, err := client.Status(); err == nil {
+		t.Fatal("expected unauthorized error")
+	}
+}
+
+func TestControlRefusesLiveSession(t *testing.T) {
+	tmp := t.TempDir()
+	s := &session{logf: t.Logf, sup: newSupervisor(t.Logf), stop: func() {}}
+	ctl, err := startControlServer(s, tmp)
+	if err != nil {
+		t.Fatalf("start control server: %v", err)
+	}
+	defer ctl.Close()
+	if _, err := startControlServer(s, tmp); err == nil {
+		t.Fatal("second control server must not take over a serving endpoint")
+	}
+	if _, err := NewControlClient(tmp); err != nil {
+		t.Fatalf("control file of the first session was removed: %v", err)
+	}
+
+	orig := processAlive
+	t.Cleanup(func() { processAlive = orig })
+	stale := t.TempDir()
+	if err := os.WriteFile(ControlFilePath(stale), []byte(`{"network":"tcp","address":"127.0.0.1:1","pid":4242}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	processAlive = func(int) bool { return true }
+	if err := checkNoLiveSession(stale); err == nil {
+		t.Fatal("expected error while the recorded pid is alive")
+	}
+	processAlive = func(int) bool { return false }
+	if err := checkNoLiveSession(stale); err != nil {
+		t.Fatalf("stale control file should be replaced: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package runner
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenControl prefers a unix socket in the conf dir and falls back to
+// loopback TCP, e.g. when the socket path is too long.
+func listenControl(confDir string) (net.Listener, string, func(), error) {
+	path := filepath.Join(confDir, controlSocketName)
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return listenLoopback()
+	}
+	_ = os.Chmod(path, 0o600)
+	return ln, "unix", func() { _ = os.Remove(path) }, nil
+}
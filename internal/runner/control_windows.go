@@ -0,0 +1,9 @@
+//go:build windows
+
+package runner
+
+import "net"
+
+func listenControl(_ string) (net.Listener, string, func(), error) {
+	return listenLoopback()
+}
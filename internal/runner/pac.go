@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"github.com/lkimju1/v2n-coremesh/internal/pac"
+)
+
+// pacServer serves the PAC file generated from the current xray config.
+type pacServer struct {
+	addr   string
+	server *http.Server
+
+	mu    sync.RWMutex
+	table *pac.Table
+}
+
+func startPACServer(s *session, addr string) (*pacServer, error) {
+	p := &pacServer{}
+	if err := p.update(s.cfg, s.assetDir(s.cfg)); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen pac server: %w", err)
+	}
+	p.addr = ln.Addr().String()
+	p.server = &http.Server{
+		Handler:           pacHandler(p),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := p.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logf("[pac] serve failed: %v", err)
+		}
+	}()
+	return p, nil
+}
+
+func (p *pacServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func pacHandler(p *pacServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /proxy.pac", p.serveScript)
+	mux.HandleFunc("GET /{$}", p.serveScript)
+	return mux
+}
+
+// update regenerates the rule table; on error the previous one is kept.
+func (p *pacServer) update(cfg *config.File, assetDir string) error {
+	table, err := pac.Load(cfg.App.GeneratedXrayConfig, assetDir)
+	if err != nil {
+		return fmt.Errorf("generate pac: %w", err)
+	}
+	p.mu.Lock()
+	p.table = table
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *pacServer) serveScript(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	table := p.table
+	p.mu.RUnlock()
+
+	w.Header().Set("Content-Type", pac.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(table.Script(pac.ProxyDirective(table.Inbound, proxyHost(table, r))))
+}
+
+// proxyHost is the address a PAC client should use for the xray inbound.
+// When xray listens on all interfaces, clients get the address they reached
+// the PAC server on, so LAN devices are not pointed at their own loopback.
+func proxyHost(table *pac.Table, r *http.Request) string {
+	if !table.Inbound.AllInterfaces {
+		return table.Inbound.Host
+	}
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return table.Inbound.Host
+	}
+	host, _, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return table.Inbound.Host
+	}
+	return host
+}
+
+// refreshPAC regenerates the PAC file after the config or assets changed.
+func (s *session) refreshPAC(cfg *config.File) {
+	if s.pac == nil {
+		return
+	}
+	if err := s.pac.update(cfg, s.assetDir(cfg)); err != nil {
+		s.logf("[pac] %v, keeping the previous script", err)
+		return
+	}
+	s.logf("[pac] regenerated")
+}
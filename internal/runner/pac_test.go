@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"github.com/lkimju1/v2n-coremesh/internal/pac"
+)
+
+func TestPACServerFollowsConfig(t *testing.T) {
+	dir := t.TempDir()
+	xrayConfig := filepath.Join(dir, "xray.json")
+	write := func(listen, domain string) {
+		content := `{
+  "inbounds": [{"tag": "http-in", "protocol": "http", "listen": "` + listen + `", "port": 10809}],
+  "outbounds": [{"tag": "proxy", "protocol": "vless"}, {"tag": "direct", "protocol": "freedom"}],
+  "routing": {"rules": [{"type": "field", "domain": ["domain:` + domain + `"], "outboundTag": "direct"}]}
+}`
+		if err := os.WriteFile(xrayConfig, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.File{}
+	cfg.App.GeneratedXrayConfig = xrayConfig
+
+	write("0.0.0.0", "first.example")
+	p := &pacServer{}
+	if err := p.update(cfg, dir); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	srv := httptest.NewServer(pacHandler(p))
+	defer srv.Close()
+
+	fetch := func() string {
+		resp, err := srv.Client().Get(srv.URL + "/proxy.pac")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != pac.ContentType {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	script := fetch()
+	if !strings.Contains(script, `"first.example":true`) || !strings.Contains(script, `"PROXY 127.0.0.1:10809"`) {
+		t.Fatalf("unexpected script:\n%s", script)
+	}
+
+	write("127.0.0.1", "second.example")
+	if err := p.update(cfg, dir); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if script := fetch(); !strings.Contains(script, `"second.example":true`) {
+		t.Fatalf("script was not regenerated:\n%s", script)
+	}
+
+	if err := os.WriteFile(xrayConfig, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(cfg, dir); err == nil {
+		t.Fatal("expected error for broken config")
+	}
+	if script := fetch(); !strings.Contains(script, `"second.example":true`) {
+		t.Fatalf("previous script should be kept:\n%s", script)
+	}
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+const (
+	defaultReadyTimeout = 10 * time.Second
+	probeInterval       = 100 * time.Millisecond
+	probeDialTimeout    = 500 * time.Millisecond
+)
+
+type readinessProbe struct {
+	address string
+	timeout time.Duration
+	socks   bool
+}
+
+func probeFromCore(c config.Core) (readinessProbe, bool) {
+	if c.Listen.Port <= 0 {
+		return readinessProbe{}, false
+	}
+	p := readinessProbe{
+		address: net.JoinHostPort(dialHost(c.Listen.Host), strconv.Itoa(c.Listen.Port)),
+		timeout: defaultReadyTimeout,
+		socks:   c.Ready.Socks,
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(c.Ready.Timeout)); err == nil && d > 0 {
+		p.timeout = d
+	}
+	return p, true
+}
+
+// dialHost maps wildcard listen addresses to a loopback address that can be dialed.
+func dialHost(host string) string {
+	h := strings.TrimSpace(strings.Trim(host, "[]"))
+	switch h {
+	case "", "0.0.0.0", "localhost":
+		return "127.0.0.1"
+	case "::", "::0":
+		return "::1"
+	default:
+		return h
+	}
+}
+
+// portReady waits until the probe succeeds, the process exits or the timeout expires.
+func portReady(p readinessProbe) func(doneCh <-chan error) error {
+	return func(doneCh <-chan error) error {
+		deadline := time.Now().Add(p.timeout)
+		for {
+			select {
+			case err := <-doneCh:
+				return exitedBeforeReady(err)
+			default:
+			}
+			lastErr := p.check()
+			if lastErr == nil {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("not ready within %s: %w", p.timeout, lastErr)
+			}
+			select {
+			case err := <-doneCh:
+				return exitedBeforeReady(err)
+			case <-time.After(probeInterval):
+			}
+		}
+	}
+}
+
+func exitedBeforeReady(err error) error {
+	if err != nil {
+		return fmt.Errorf("exited before ready: %w", err)
+	}
+	return fmt.Errorf("exited before ready: process exited")
+}
+
+func (p readinessProbe) check() error {
+	conn, err := net.DialTimeout("tcp", p.address, probeDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !p.socks {
+		return nil
+	}
+	_ = conn.SetDeadline(time.Now().Add(probeDialTimeout))
+	// SOCKS5 greeting offering "no authentication" and "username/password".
+	if _, err := conn.Write([]byte{0x05, 0x02, 0x00, 0x02}); err != nil {
+		return fmt.Errorf("socks5 greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("socks5 greeting reply: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("socks5 greeting: unexpected version %d", reply[0])
+	}
+	if reply[1] == 0xff {
+		return fmt.Errorf("socks5 greeting: no acceptable auth method")
+	}
+	return nil
+}
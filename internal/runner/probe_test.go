@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestProbeFromCore(t *testing.T) {
+	if _, ok := probeFromCore(config.Core{}); ok {
+		t.Fatal("core without listen port should not be probed")
+	}
+	p, ok := probeFromCore(config.Core{
+		Listen: config.Listen{Host: "0.0.0.0", Port: 1080},
+		Ready:  config.Ready{Timeout: "3s", Socks: true},
+	})
+	if !ok || p.address != "127.0.0.1:1080" || p.timeout != 3*time.Second || !p.socks {
+		t.Fatalf("unexpected probe: %#v", p)
+	}
+	p, _ = probeFromCore(config.Core{Listen: config.Listen{Host: "::", Port: 1080}})
+	if p.address != "[::1]:1080" || p.timeout != defaultReadyTimeout {
+		t.Fatalf("unexpected probe: %#v", p)
+	}
+}
+
+func TestPortReadyAcceptsSocksListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			greeting := make([]byte, 4)
+			if _, err := io.ReadFull(conn, greeting); err == nil {
+				_, _ = conn.Write([]byte{0x05, 0x00})
+			}
+			conn.Close()
+		}
+	}()
+
+	probe := readinessProbe{address: ln.Addr().String(), timeout: time.Second, socks: true}
+	if err := portReady(probe)(make(chan error)); err != nil {
+		t.Fatalf("expected ready: %v", err)
+	}
+}
+
+func TestPortReadyRejectsNonSocksListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	probe := readinessProbe{address: ln.Addr().String(), timeout: 300 * time.Millisecond, socks: true}
+	err = portReady(probe)(make(chan error))
+	if err == nil || !strings.Contains(err.Error(), "not ready within") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestPortReadyReportsEarlyExit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	doneCh := make(chan error, 1)
+	doneCh <- errors.New("exit status 2")
+	probe := readinessProbe{address: addr, timeout: 5 * time.Second}
+	err = portReady(probe)(doneCh)
+	if err == nil || !strings.Contains(err.Error(), "exited before ready: exit status 2") {
+		t.Fatalf("expected early exit error, got %v", err)
+	}
+}
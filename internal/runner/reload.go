@@ -0,0 +1,209 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+const watchInterval = 2 * time.Second
+
+type reloadPlan struct {
+	remove      []string
+	restart     []config.Core
+	add         []config.Core
+	update      []config.Core
+	restartXray bool
+}
+
+func (p reloadPlan) empty() bool {
+	return len(p.remove) == 0 && len(p.restart) == 0 && len(p.add) == 0 && len(p.update) == 0 && !p.restartXray
+}
+
+// planReload compares the fingerprints of the running processes with the
+// next config and decides which processes need to be touched.
+func planReload(running map[string]string, runningXray string, next *config.File) reloadPlan {
+	var plan reloadPlan
+	seen := make(map[string]struct{}, len(next.Cores))
+	for _, c := range next.Cores {
+		key := coreKey(c)
+		seen[key] = struct{}{}
+		fp, ok := running[key]
+		switch {
+		case !ok:
+			plan.add = append(plan.add, c)
+		case fp != fingerprintCore(c):
+			plan.restart = append(plan.restart, c)
+		default:
+			plan.update = append(plan.update, c)
+		}
+	}
+	for key := range running {
+		if _, ok := seen[key]; !ok {
+			plan.remove = append(plan.remove, key)
+		}
+	}
+	plan.restartXray = runningXray != fingerprintXray(next)
+	return plan
+}
+
+// fingerprintCore covers everything that requires a process restart when it
+// changes, including the content of the core config file.
+func fingerprintCore(c config.Core) string {
+	h := sha256.New()
+	writeField(h, c.Bin)
+	writeField(h, c.Config)
+	writeField(h, strings.Join(c.Args, "\x00"))
+	writeField(h, c.Listen.Host)
+	writeField(h, strconv.Itoa(c.Listen.Port))
+	writeFileContent(h, c.Config)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func fingerprintXray(cfg *config.File) string {
+	h := sha256.New()
+	writeField(h, cfg.Xray.Bin)
+	writeField(h, cfg.App.GeneratedXrayConfig)
+	writeField(h, strings.Join(cfg.Xray.Args, "\x00"))
+	writeFileContent(h, cfg.App.GeneratedXrayConfig)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeField(w io.Writer, v string) {
+	_, _ = io.WriteString(w, v)
+	_, _ = w.Write([]byte{0})
+}
+
+func writeFileContent(w io.Writer, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		writeField(w, "missing")
+		return
+	}
+	defer f.Close()
+	_, _ = io.Copy(w, f)
+}
+
+// reload re-parses the sources through Options.Reload and applies the result.
+func (s *session) reload() error {
+	if s.opts.Reload == nil {
+		return fmt.Errorf("reload is not supported by this session")
+	}
+	return s.reloadFrom("re-parse", s.opts.Reload)
+}
+
+func (s *session) reloadFrom(source string, load func() (*config.File, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.markWatched()
+
+	next, err := load()
+	if err != nil {
+		s.logf("[reload] %s failed: %v", source, err)
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return s.apply(next)
+}
+
+// apply switches the session to next, touching only processes whose
+// fingerprint changed. Errors are collected so one failing core does not
+// prevent the rest of the plan from being applied.
+func (s *session) apply(next *config.File) error {
+	plan := planReload(s.coreFP, s.xrayFP, next)
+	if plan.empty() {
+		s.logf("[reload] no changes")
+		s.cfg = next
+		return nil
+	}
+	var errs []error
+	for _, key := range plan.remove {
+		s.logf("[reload] stopping removed core %s", key)
+		s.sup.remove(key)
+		delete(s.coreFP, key)
+	}
+	for _, c := range plan.restart {
+		key := coreKey(c)
+		s.logf("[reload] restarting changed core %s", c.Name)
+		s.sup.remove(key)
+		delete(s.coreFP, key)
+		if err := s.startCore(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, c := range plan.add {
+		s.logf("[reload] starting new core %s", c.Name)
+		if err := s.startCore(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, c := range plan.update {
+		s.sup.updatePolicy(coreKey(c), policyFromConfig(c.Restart, RestartOnFailure))
+	}
+	if plan.restartXray {
+		s.logf("[reload] restarting xray with changed config")
+		s.sup.remove(xrayProcessKey)
+		s.xrayFP = ""
+		if err := s.startXray(next); err != nil {
+			errs = append(errs, err)
+		}
+		s.refreshPAC(next)
+	} else {
+		s.sup.updatePolicy(xrayProcessKey, policyFromConfig(next.Xray.Restart, RestartNever))
+	}
+	s.cfg = next
+	if err := errors.Join(errs...); err != nil {
+		s.logf("[reload] finished with errors: %v", err)
+		return err
+	}
+	s.logf("[reload] done: %d removed, %d restarted, %d added, xray restarted=%t",
+		len(plan.remove), len(plan.restart), len(plan.add), plan.restartXray)
+	return nil
+}
+
+// watchChanged reports whether the watched file changed since the last reload.
+func (s *session) watchChanged() bool {
+	st, err := os.Stat(s.opts.WatchFile)
+	if err != nil {
+		return false
+	}
+	return !st.ModTime().Equal(s.watchedMod) || st.Size() != s.watchedSize
+}
+
+func (s *session) markWatched() {
+	if s.opts.WatchFile == "" {
+		return
+	}
+	if st, err := os.Stat(s.opts.WatchFile); err == nil {
+		s.watchedMod = st.ModTime()
+		s.watchedSize = st.Size()
+	}
+}
+
+// pollWatchFile reloads through Options.Load whenever the watched file changes.
+func (s *session) pollWatchFile(done <-chan struct{}) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		changed := s.watchChanged()
+		s.mu.Unlock()
+		if !changed {
+			continue
+		}
+		s.logf("[reload] %s changed", s.opts.WatchFile)
+		_ = s.reloadFrom("load state", s.opts.Load)
+	}
+}
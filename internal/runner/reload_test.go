@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestPlanReload(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(tmp, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	xrayCfg := write("xray.generated.json", `{"outbounds":[]}`)
+	cfgA := write("a.json", `{"listen":"127.0.0.1:1081"}`)
+	cfgB := write("b.json", `{"listen":"127.0.0.1:1082"}`)
+	cfgC := write("c.json", `{"listen":"127.0.0.1:1083"}`)
+
+	oldCfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: xrayCfg},
+		Xray: config.Xray{Bin: "/bin/xray", Args: []string{"run"}},
+		Cores: []config.Core{
+			{Name: "a", Alias: "a", Bin: "/bin/core", Config: cfgA, Listen: config.Listen{Host: "127.0.0.1", Port: 1081}},
+			{Name: "b", Alias: "b", Bin: "/bin/core", Config: cfgB, Listen: config.Listen{Host: "127.0.0.1", Port: 1082}},
+			{Name: "gone", Alias: "gone", Bin: "/bin/core", Config: cfgC, Listen: config.Listen{Host: "127.0.0.1", Port: 1083}},
+		},
+	}
+	running := make(map[string]string)
+	for _, c := range oldCfg.Cores {
+		running[coreKey(c)] = fingerprintCore(c)
+	}
+	runningXray := fingerprintXray(oldCfg)
+
+	if plan := planReload(running, runningXray, oldCfg); len(plan.add)+len(plan.remove)+len(plan.restart) != 0 || plan.restartXray {
+		t.Fatalf("identical config should not touch processes: %#v", plan)
+	}
+
+	// b's config content changes, a gets new restart settings only, gone is removed, new is added.
+	write("b.json", `{"listen":"127.0.0.1:1092"}`)
+	cfgNew := write("new.json", `{"listen":"127.0.0.1:1084"}`)
+	next := &config.File{
+		App:  oldCfg.App,
+		Xray: oldCfg.Xray,
+		Cores: []config.Core{
+			{Name: "a", Alias: "a", Bin: "/bin/core", Config: cfgA, Listen: config.Listen{Host: "127.0.0.1", Port: 1081}, Restart: config.Restart{Policy: "always"}},
+			{Name: "b", Alias: "b", Bin: "/bin/core", Config: cfgB, Listen: config.Listen{Host: "127.0.0.1", Port: 1082}},
+			{Name: "new", Alias: "new", Bin: "/bin/core", Config: cfgNew, Listen: config.Listen{Host: "127.0.0.1", Port: 1084}},
+		},
+	}
+	plan := planReload(running, runningXray, next)
+	if len(plan.remove) != 1 || plan.remove[0] != "gone" {
+		t.Fatalf("unexpected removed cores: %#v", plan.remove)
+	}
+	if names := coreNames(plan.restart); len(names) != 1 || names[0] != "b" {
+		t.Fatalf("unexpected restarted cores: %#v", names)
+	}
+	if names := coreNames(plan.add); len(names) != 1 || names[0] != "new" {
+		t.Fatalf("unexpected added cores: %#v", names)
+	}
+	if names := coreNames(plan.update); len(names) != 1 || names[0] != "a" {
+		t.Fatalf("unexpected updated cores: %#v", names)
+	}
+	if plan.restartXray {
+		t.Fatal("xray should not restart when its config is unchanged")
+	}
+
+	write("xray.generated.json", `{"outbounds":[{"tag":"new"}]}`)
+	if plan := planReload(running, runningXray, next); !plan.restartXray {
+		t.Fatal("xray should restart when the generated config changed")
+	}
+}
+
+func coreNames(cores []config.Core) []string {
+	out := make([]string, 0, len(cores))
+	for _, c := range cores {
+		out = append(out, c.Name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -3,8 +3,10 @@ package runner
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -15,10 +17,50 @@ import (
 	"github.com/lkimju1/v2n-coremesh/internal/sysproxy"
 )
 
-type Process struct {
-	name   string
-	cmd    *exec.Cmd
-	doneCh chan error
+const xrayProcessKey = "xray"
+
+// Options controls optional behaviour of a run session.
+type Options struct {
+	AssetDir string
+	Logger   *applog.Logger
+	// ControlDir enables the control endpoint, announced in that directory.
+	ControlDir string
+	// Reload re-parses the sources and returns the runtime config to switch to.
+	// It is used by the control API and on SIGHUP.
+	Reload func() (*config.File, error)
+	// Load returns the runtime config from the current state without
+	// re-parsing. It is used when WatchFile changes.
+	Load      func() (*config.File, error)
+	WatchFile string
+	// RefreshAssets re-downloads stale assets through proxy, xray's local
+	// inbound, and reports whether any file changed; xray is then restarted.
+	RefreshAssets func(proxy *url.URL) (bool, error)
+	// RefreshAssetsOnStart runs RefreshAssets once the mesh is up, for assets
+	// that could not be downloaded directly before any core was running.
+	RefreshAssetsOnStart bool
+	// RefreshInterval schedules RefreshAssets while the session runs; zero
+	// disables periodic checks.
+	RefreshInterval time.Duration
+	// PACListen serves a PAC file generated from the routing rules on this
+	// address; empty disables it.
+	PACListen string
+}
+
+type session struct {
+	opts      Options
+	logf      func(format string, args ...any)
+	sup       *supervisor
+	xrayLog   *os.File
+	startedAt time.Time
+	stop      context.CancelFunc
+	pac       *pacServer
+
+	mu          sync.Mutex
+	cfg         *config.File
+	coreFP      map[string]string
+	xrayFP      string
+	watchedMod  time.Time
+	watchedSize int64
 }
 
 func Run(cfg *config.File) error {
@@ -26,14 +68,25 @@ func Run(cfg *config.File) error {
 }
 
 func RunWithAssetDir(ctx context.Context, cfg *config.File, assetDir string, logger *applog.Logger) error {
+	return RunWithOptions(ctx, cfg, Options{AssetDir: assetDir, Logger: logger})
+}
+
+func RunWithOptions(ctx context.Context, cfg *config.File, opts Options) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	if opts.ControlDir != "" {
+		if err := checkNoLiveSession(opts.ControlDir); err != nil {
+			return err
+		}
+	}
+
 	workDir := strings.TrimSpace(cfg.App.WorkDir)
 	if workDir == "" {
 		workDir = "."
 	}
-	started := make([]Process, 0, len(cfg.Cores)+1)
 	restoreProxy := func() error { return nil }
 	proxyChanged := false
 	xrayLogPath := filepath.Join(workDir, applog.XrayLogFileName)
@@ -44,10 +97,20 @@ func RunWithAssetDir(ctx context.Context, cfg *config.File, assetDir string, log
 	defer xrayLog.Close()
 
 	logf := func(format string, args ...any) {
-		if logger == nil {
+		if opts.Logger == nil {
 			return
 		}
-		logger.Printf(format, args...)
+		opts.Logger.Printf(format, args...)
+	}
+	s := &session{
+		opts:      opts,
+		logf:      logf,
+		sup:       newSupervisor(logf),
+		xrayLog:   xrayLog,
+		startedAt: time.Now(),
+		stop:      stop,
+		cfg:       cfg,
+		coreFP:    make(map[string]string),
 	}
 
 	var cleanupOnce sync.Once
@@ -58,69 +121,23 @@ func RunWithAssetDir(ctx context.Context, cfg *config.File, assetDir string, log
 			} else if proxyChanged {
 				logf("[sysproxy] restored previous system proxy settings")
 			}
-			for i := len(started) - 1; i >= 0; i-- {
-				p := started[i]
-				if p.cmd.Process != nil {
-					_ = p.cmd.Process.Kill()
-				}
-				select {
-				case <-p.doneCh:
-				case <-time.After(2 * time.Second):
-				}
-			}
+			s.sup.stopAll()
 		})
 	}
 	defer cleanup()
 
-	for _, c := range cfg.Cores {
-		args := replaceConfigPlaceholder(c.Args, c.Config)
-		cmd := exec.Command(c.Bin, args...)
-		if logger != nil && logger.Writer() != nil {
-			cmd.Stdout = logger.Writer()
-			cmd.Stderr = logger.Writer()
-		}
-		doneCh := make(chan error, 1)
-		logf("[core] starting %s: %s %s", c.Name, c.Bin, strings.Join(args, " "))
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("start core %s failed: %w", c.Name, err)
-		}
-		go func() {
-			doneCh <- cmd.Wait()
-		}()
-		started = append(started, Process{name: c.Name, cmd: cmd, doneCh: doneCh})
-		if err := waitHealthy(doneCh, 600*time.Millisecond); err != nil {
-			return fmt.Errorf("core %s exited early: %w", c.Name, err)
-		}
-		logf("[core] started %s", c.Name)
-	}
-
-	xrayArgs := replaceConfigPlaceholder(cfg.Xray.Args, cfg.App.GeneratedXrayConfig)
-	xrayCmd := exec.Command(cfg.Xray.Bin, xrayArgs...)
-	xrayCmd.Stdout = xrayLog
-	xrayCmd.Stderr = xrayLog
-	if assetDir == "" {
-		assetDir = inferXrayAssetDir(cfg.Xray.Bin)
-	}
-	xrayCmd.Env = append(os.Environ(),
-		"XRAY_LOCATION_ASSET="+assetDir,
-		"XRAY_LOCATION_CERT="+assetDir,
-	)
-	xrayDone := make(chan error, 1)
-	logf("[xray] starting: %s %s", cfg.Xray.Bin, strings.Join(xrayArgs, " "))
-	logf("[xray] log file: %s", xrayLogPath)
-	if err := xrayCmd.Start(); err != nil {
-		return fmt.Errorf("start xray failed: %w", err)
+	if restored, err := sysproxy.RestoreJournal(workDir, false); err != nil {
+		return fmt.Errorf("restore leftover system proxy: %w", err)
+	} else if restored {
+		logf("[sysproxy] restored settings left over by a previous session")
 	}
-	go func() {
-		xrayDone <- xrayCmd.Wait()
-	}()
-	started = append(started, Process{name: "xray", cmd: xrayCmd, doneCh: xrayDone})
-	if err := waitHealthy(xrayDone, 600*time.Millisecond); err != nil {
-		return fmt.Errorf("xray exited early: %w", err)
+
+	logf("[xray] log file: %s", xrayLogPath)
+	if err := s.startAll(cfg); err != nil {
+		return err
 	}
-	logf("[xray] started")
 
-	proxyRestore, changed, err := sysproxy.ConfigureForRun(cfg.App.GeneratedXrayConfig)
+	proxyRestore, changed, err := sysproxy.ConfigureForRun(workDir, cfg.App.GeneratedXrayConfig)
 	if err != nil {
 		return fmt.Errorf("configure system proxy: %w", err)
 	}
@@ -129,18 +146,170 @@ func RunWithAssetDir(ctx context.Context, cfg *config.File, assetDir string, log
 	if changed {
 		logf("[sysproxy] enabled and pointed to xray inbound")
 	} else {
-		logf("[sysproxy] unchanged (already configured or unsupported platform)")
+		logf("[sysproxy] unchanged (already configured, inbound requires an account or unsupported platform)")
 	}
 
-	select {
-	case err := <-xrayDone:
+	if opts.PACListen != "" {
+		pacSrv, err := startPACServer(s, opts.PACListen)
 		if err != nil {
-			return fmt.Errorf("xray exited with error: %w", err)
+			return fmt.Errorf("start pac server: %w", err)
+		}
+		defer pacSrv.Close()
+		s.pac = pacSrv
+		logf("[pac] serving http://%s/proxy.pac", pacSrv.addr)
+	}
+
+	if opts.ControlDir != "" {
+		ctl, err := startControlServer(s, opts.ControlDir)
+		if err != nil {
+			return fmt.Errorf("start control endpoint: %w", err)
+		}
+		defer ctl.Close()
+		logf("[control] listening on %s", ctl.endpoint)
+	}
+
+	if opts.RefreshAssets != nil && (opts.RefreshAssetsOnStart || opts.RefreshInterval > 0) {
+		refreshDone := make(chan struct{})
+		defer close(refreshDone)
+		go s.assetRefreshLoop(refreshDone)
+	}
+
+	var hup chan os.Signal
+	if opts.Reload != nil && len(reloadSignals) > 0 {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, reloadSignals...)
+		defer signal.Stop(hup)
+	}
+	if opts.Load != nil && opts.WatchFile != "" {
+		s.markWatched()
+		watchDone := make(chan struct{})
+		defer close(watchDone)
+		go s.pollWatchFile(watchDone)
+		logf("[reload] watching %s", opts.WatchFile)
+	}
+
+	for {
+		select {
+		case <-hup:
+			logf("[reload] SIGHUP received")
+			go func() { _ = s.reload() }()
+		case ev := <-s.sup.gaveUp:
+			if ev.key == xrayProcessKey {
+				if ev.err != nil {
+					return fmt.Errorf("xray exited with error: %w", ev.err)
+				}
+				logf("[xray] exited")
+				return nil
+			}
+			logf("[supervisor] %s stopped permanently, its outbound is unavailable", ev.name)
+		case <-ctx.Done():
+			logf("[run] shutdown requested: %v", ctx.Err())
+			return nil
+		}
+	}
+}
+
+// startAll starts the cores in order, waiting for each to become ready, and
+// then starts xray.
+func (s *session) startAll(cfg *config.File) error {
+	for _, c := range cfg.Cores {
+		if err := s.startCore(c); err != nil {
+			return err
+		}
+	}
+	return s.startXray(cfg)
+}
+
+func (s *session) startCore(c config.Core) error {
+	spec := coreSpec(c, s.opts.Logger)
+	ready := graceReady(600 * time.Millisecond)
+	if probe, ok := probeFromCore(c); ok {
+		ready = portReady(probe)
+	}
+	s.logf("[core] starting %s: %s %s", c.Name, c.Bin, strings.Join(spec.args, " "))
+	fp := fingerprintCore(c)
+	if _, err := s.sup.launch(spec, ready); err != nil {
+		s.logf("[core] %s failed to become ready: %v", c.Name, err)
+		return fmt.Errorf("core %s: %w", c.Name, err)
+	}
+	s.coreFP[spec.key] = fp
+	s.logf("[core] %s ready", c.Name)
+	return nil
+}
+
+func (s *session) startXray(cfg *config.File) error {
+	xray := xraySpec(cfg, s.assetDir(cfg), s.xrayLog)
+	s.logf("[xray] starting: %s %s", cfg.Xray.Bin, strings.Join(xray.args, " "))
+	fp := fingerprintXray(cfg)
+	if _, err := s.sup.launch(xray, graceReady(600*time.Millisecond)); err != nil {
+		return fmt.Errorf("xray: %w", err)
+	}
+	s.xrayFP = fp
+	s.logf("[xray] started")
+	return nil
+}
+
+// assetDir is where xray looks up geo files for cfg.
+func (s *session) assetDir(cfg *config.File) string {
+	if s.opts.AssetDir != "" {
+		return s.opts.AssetDir
+	}
+	return inferXrayAssetDir(cfg.Xray.Bin)
+}
+
+func (s *session) restart(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sup.restart(name); err != nil {
+		return err
+	}
+	s.logf("[control] restart requested for %s", name)
+	return nil
+}
+
+// coreKey identifies a core process by its outbound tag, which validate
+// keeps unique and distinct from xrayProcessKey.
+func coreKey(c config.Core) string {
+	if tag := c.Tag(); tag != "" {
+		return tag
+	}
+	return c.Name
+}
+
+func coreSpec(c config.Core, logger *applog.Logger) processSpec {
+	spec := processSpec{
+		key:    coreKey(c),
+		name:   "core " + c.Name,
+		bin:    c.Bin,
+		args:   replaceConfigPlaceholder(c.Args, c.Config),
+		policy: policyFromConfig(c.Restart, RestartOnFailure),
+	}
+	if logger != nil && logger.Writer() != nil {
+		spec.output = logger.Writer()
+	}
+	return spec
+}
+
+func xraySpec(cfg *config.File, assetDir string, xrayLog *os.File) processSpec {
+	return processSpec{
+		key:  xrayProcessKey,
+		name: "xray",
+		bin:  cfg.Xray.Bin,
+		args: replaceConfigPlaceholder(cfg.Xray.Args, cfg.App.GeneratedXrayConfig),
+		env: append(os.Environ(),
+			"XRAY_LOCATION_ASSET="+assetDir,
+			"XRAY_LOCATION_CERT="+assetDir,
+		),
+		output: xrayLog,
+		policy: policyFromConfig(cfg.Xray.Restart, RestartNever),
+	}
+}
+
+func graceReady(grace time.Duration) func(doneCh <-chan error) error {
+	return func(doneCh <-chan error) error {
+		if err := waitHealthy(doneCh, grace); err != nil {
+			return fmt.Errorf("exited early: %w", err)
 		}
-		logf("[xray] exited")
-		return nil
-	case <-ctx.Done():
-		logf("[run] shutdown requested: %v", ctx.Err())
 		return nil
 	}
 }
@@ -169,6 +338,11 @@ func replaceConfigPlaceholder(args []string, configPath string) []string {
 }
 
 func inferXrayAssetDir(xrayBin string) string {
+	if !strings.ContainsAny(xrayBin, `/\`) {
+		if resolved, err := exec.LookPath(xrayBin); err == nil {
+			xrayBin = resolved
+		}
+	}
 	// v2rayN layout: <home>/bin/xray/xray, assets under <home>/bin
 	dir := filepath.Dir(xrayBin)
 	parent := filepath.Dir(dir)
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"syscall"
+)
+
+var reloadSignals = []os.Signal{syscall.SIGHUP}
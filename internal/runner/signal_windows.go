@@ -0,0 +1,7 @@
+//go:build windows
+
+package runner
+
+import "os"
+
+var reloadSignals []os.Signal
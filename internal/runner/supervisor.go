@@ -0,0 +1,535 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+	stableRunDuration        = 30 * time.Second
+	crashLoopWindow          = 2 * time.Minute
+	crashLoopThreshold       = 5
+	crashLoopCooldown        = 10 * time.Minute
+	stopTimeout              = 2 * time.Second
+)
+
+const (
+	stateRunning = "running"
+	stateBackoff = "backoff"
+	stateStopped = "stopped"
+	stateFailed  = "failed"
+)
+
+// errStopping is returned by start when stop raced with a restart.
+var errStopping = errors.New("process is stopping")
+
+type restartPolicy struct {
+	mode       string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+type processSpec struct {
+	key    string
+	name   string
+	bin    string
+	args   []string
+	env    []string
+	output io.Writer
+	policy restartPolicy
+}
+
+type supervised struct {
+	spec processSpec
+
+	mu               sync.Mutex
+	cmd              *exec.Cmd
+	doneCh           chan error
+	startedAt        time.Time
+	state            string
+	restarts         int
+	consecutive      int
+	exits            []time.Time
+	stopping         bool
+	restartRequested bool
+	stopCh           chan struct{}
+	exited           chan struct{}
+}
+
+type exitEvent struct {
+	key  string
+	name string
+	err  error
+}
+
+// ProcessStatus is a point-in-time view of a supervised process.
+type ProcessStatus struct {
+	Name      string    `json:"name"`
+	Command   string    `json:"command"`
+	PID       int       `json:"pid"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+	Restarts  int       `json:"restarts"`
+}
+
+type supervisor struct {
+	logf func(format string, args ...any)
+
+	mu     sync.Mutex
+	procs  map[string]*supervised
+	order  []string
+	gaveUp chan exitEvent
+}
+
+func newSupervisor(logf func(format string, args ...any)) *supervisor {
+	return &supervisor{
+		logf:   logf,
+		procs:  make(map[string]*supervised),
+		gaveUp: make(chan exitEvent, 16),
+	}
+}
+
+func policyFromConfig(r config.Restart, defaultMode string) restartPolicy {
+	p := restartPolicy{
+		mode:       strings.ToLower(strings.TrimSpace(r.Policy)),
+		maxRetries: r.MaxRetries,
+		backoff:    defaultRestartBackoff,
+		maxBackoff: defaultRestartMaxBackoff,
+	}
+	if p.mode == "" {
+		p.mode = defaultMode
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(r.Backoff)); err == nil && d > 0 {
+		p.backoff = d
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(r.MaxBackoff)); err == nil && d > 0 {
+		p.maxBackoff = d
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	return p
+}
+
+func (p restartPolicy) shouldRestart(exitErr error) bool {
+	switch p.mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// delay returns the backoff before the n-th consecutive restart (1-based).
+func (p restartPolicy) delay(n int) time.Duration {
+	d := p.backoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= p.maxBackoff {
+			return p.maxBackoff
+		}
+	}
+	return d
+}
+
+// launch starts the process, waits for ready to accept it and then hands it
+// over to the supervision loop. A process that fails ready is not registered.
+func (s *supervisor) launch(spec processSpec, ready func(doneCh <-chan error) error) (*supervised, error) {
+	if s.live(spec.key) {
+		return nil, fmt.Errorf("start %s failed: process %q is already running", spec.name, spec.key)
+	}
+	p := &supervised{
+		spec:   spec,
+		stopCh: make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	if err := p.start(); err != nil {
+		return nil, fmt.Errorf("start %s failed: %w", spec.name, err)
+	}
+	if ready != nil {
+		if err := ready(p.doneCh); err != nil {
+			p.kill()
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	old, exists := s.procs[spec.key]
+	if exists && !old.done() {
+		s.mu.Unlock()
+		p.kill()
+		return nil, fmt.Errorf("start %s failed: process %q is already running", spec.name, spec.key)
+	}
+	if !exists {
+		s.order = append(s.order, spec.key)
+	}
+	s.procs[spec.key] = p
+	s.mu.Unlock()
+
+	go s.watch(p)
+	return p, nil
+}
+
+// live reports whether key names a process that is still supervised, as
+// opposed to one that failed for good.
+func (s *supervisor) live(key string) bool {
+	p, ok := s.get(key)
+	return ok && !p.done()
+}
+
+// done reports whether the supervision loop of p has ended.
+func (p *supervised) done() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *supervisor) get(key string) (*supervised, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.procs[key]
+	return p, ok
+}
+
+// restart kills the process and starts it again immediately, without
+// counting the exit against its restart policy. A process that was given up
+// on is started afresh.
+func (s *supervisor) restart(key string) error {
+	p, ok := s.get(key)
+	if !ok {
+		return fmt.Errorf("unknown process %q", key)
+	}
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		return fmt.Errorf("process %q is stopping", key)
+	}
+	if p.state == stateBackoff {
+		p.mu.Unlock()
+		return fmt.Errorf("process %q is already waiting to restart", key)
+	}
+	if p.state == stateFailed {
+		spec := p.spec
+		p.mu.Unlock()
+		<-p.exited
+		_, err := s.launch(spec, nil)
+		return err
+	}
+	p.restartRequested = true
+	cmd := p.cmd
+	p.mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+	return nil
+}
+
+// updatePolicy changes the restart policy without restarting the process.
+func (s *supervisor) updatePolicy(key string, policy restartPolicy) {
+	p, ok := s.get(key)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	p.spec.policy = policy
+	p.mu.Unlock()
+}
+
+func (s *supervisor) status(now time.Time) []ProcessStatus {
+	s.mu.Lock()
+	procs := make([]*supervised, 0, len(s.order))
+	for _, key := range s.order {
+		procs = append(procs, s.procs[key])
+	}
+	s.mu.Unlock()
+
+	out := make([]ProcessStatus, 0, len(procs))
+	for _, p := range procs {
+		p.mu.Lock()
+		st := ProcessStatus{
+			Name:      p.spec.key,
+			Command:   p.spec.name,
+			State:     p.state,
+			StartedAt: p.startedAt,
+			Restarts:  p.restarts,
+		}
+		if p.state == stateRunning {
+			if p.cmd != nil && p.cmd.Process != nil {
+				st.PID = p.cmd.Process.Pid
+			}
+			st.Uptime = now.Sub(p.startedAt).Round(time.Second).String()
+		}
+		p.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// remove stops the process and forgets it.
+func (s *supervisor) remove(key string) {
+	s.mu.Lock()
+	p, ok := s.procs[key]
+	if ok {
+		delete(s.procs, key)
+		for i, k := range s.order {
+			if k == key {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if ok {
+		p.stop()
+	}
+}
+
+// stopAll stops every process in reverse start order and forgets them.
+func (s *supervisor) stopAll() {
+	s.mu.Lock()
+	procs := make([]*supervised, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		procs = append(procs, s.procs[s.order[i]])
+	}
+	s.procs = make(map[string]*supervised)
+	s.order = nil
+	s.mu.Unlock()
+	for _, p := range procs {
+		p.stop()
+	}
+}
+
+func (s *supervisor) watch(p *supervised) {
+	defer close(p.exited)
+	for {
+		p.mu.Lock()
+		doneCh := p.doneCh
+		stopCh := p.stopCh
+		p.mu.Unlock()
+
+		var exitErr error
+		select {
+		case exitErr = <-doneCh:
+		case <-stopCh:
+			exitErr = <-doneCh
+		}
+
+		now := time.Now()
+		p.mu.Lock()
+		if p.stopping {
+			p.state = stateStopped
+			p.mu.Unlock()
+			return
+		}
+		manual := p.restartRequested
+		p.restartRequested = false
+		uptime := now.Sub(p.startedAt)
+		var delay time.Duration
+		var reason string
+		attempt := p.restarts + 1
+		if manual {
+			p.consecutive = 0
+		} else {
+			delay, reason = p.recordExitLocked(exitErr, now, uptime)
+		}
+		if reason != "" {
+			p.state = stateFailed
+		} else {
+			p.state = stateBackoff
+		}
+		p.mu.Unlock()
+
+		if manual {
+			s.logf("[supervisor] %s restarting on request", p.spec.name)
+		} else {
+			s.logf("[supervisor] %s exited after %s: %s", p.spec.name, uptime.Round(time.Millisecond), describeExit(exitErr))
+			if reason != "" {
+				s.logf("[supervisor] %s not restarted: %s", p.spec.name, reason)
+				select {
+				case s.gaveUp <- exitEvent{key: p.spec.key, name: p.spec.name, err: exitErr}:
+				default:
+				}
+				return
+			}
+			s.logf("[supervisor] %s restarting in %s (restart #%d)", p.spec.name, delay, attempt)
+			select {
+			case <-time.After(delay):
+			case <-stopCh:
+				p.mu.Lock()
+				p.state = stateStopped
+				p.mu.Unlock()
+				return
+			}
+		}
+
+		p.mu.Lock()
+		if p.stopping {
+			p.state = stateStopped
+			p.mu.Unlock()
+			return
+		}
+		p.restarts++
+		p.mu.Unlock()
+		if err := p.start(); err != nil {
+			if !errors.Is(err, errStopping) {
+				s.logf("[supervisor] %s restart failed: %v", p.spec.name, err)
+			}
+			continue
+		}
+		s.logf("[supervisor] %s restarted (pid %d)", p.spec.name, p.pid())
+	}
+}
+
+// recordExitLocked applies the restart policy and crash-loop detection to an
+// unexpected exit. It returns the backoff delay, or a non-empty reason when
+// the process must not be restarted.
+func (p *supervised) recordExitLocked(exitErr error, now time.Time, uptime time.Duration) (time.Duration, string) {
+	policy := p.spec.policy
+	if !policy.shouldRestart(exitErr) {
+		return 0, fmt.Sprintf("restart policy %q", policy.mode)
+	}
+
+	if uptime >= stableRunDuration {
+		p.consecutive = 0
+	}
+	p.consecutive++
+	if policy.maxRetries > 0 && p.consecutive > policy.maxRetries {
+		return 0, fmt.Sprintf("max retries (%d) exceeded", policy.maxRetries)
+	}
+
+	kept := p.exits[:0]
+	for _, t := range p.exits {
+		if now.Sub(t) < crashLoopWindow {
+			kept = append(kept, t)
+		}
+	}
+	p.exits = append(kept, now)
+	if len(p.exits) >= crashLoopThreshold {
+		// With unlimited retries a crash loop only pauses restarts.
+		if policy.maxRetries == 0 {
+			p.exits = p.exits[:0]
+			return crashLoopCooldown, ""
+		}
+		return 0, fmt.Sprintf("crash loop detected (%d exits within %s)", len(p.exits), crashLoopWindow)
+	}
+	return policy.delay(p.consecutive), ""
+}
+
+func (p *supervised) start() error {
+	cmd := exec.Command(p.spec.bin, p.spec.args...)
+	if p.spec.output != nil {
+		cmd.Stdout = p.spec.output
+		cmd.Stderr = p.spec.output
+	}
+	if len(p.spec.env) > 0 {
+		cmd.Env = p.spec.env
+	}
+	doneCh := make(chan error, 1)
+	if err := cmd.Start(); err != nil {
+		doneCh <- err
+		close(doneCh)
+		p.mu.Lock()
+		p.doneCh = doneCh
+		p.startedAt = time.Now()
+		p.mu.Unlock()
+		return err
+	}
+	go func() {
+		doneCh <- cmd.Wait()
+		close(doneCh)
+	}()
+	// Publishing cmd and checking stopping in one critical section means
+	// stop either sees this process or this check sees stop.
+	p.mu.Lock()
+	p.cmd = cmd
+	p.doneCh = doneCh
+	p.startedAt = time.Now()
+	if p.stopping {
+		p.mu.Unlock()
+		_ = cmd.Process.Kill()
+		return errStopping
+	}
+	p.state = stateRunning
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *supervised) kill() {
+	p.mu.Lock()
+	cmd := p.cmd
+	doneCh := p.doneCh
+	p.mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-doneCh:
+	case <-time.After(stopTimeout):
+	}
+}
+
+func (p *supervised) stop() {
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		select {
+		case <-p.exited:
+		case <-time.After(stopTimeout):
+		}
+		return
+	}
+	p.stopping = true
+	close(p.stopCh)
+	cmd := p.cmd
+	p.mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+	}
+	// watch may have replaced the process before it saw stopping.
+	p.mu.Lock()
+	last := p.cmd
+	p.mu.Unlock()
+	if last != cmd && last != nil && last.Process != nil {
+		_ = last.Process.Kill()
+	}
+}
+
+func (p *supervised) pid() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+func describeExit(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func TestPolicyFromConfig(t *testing.T) {
+	p := policyFromConfig(config.Restart{}, RestartOnFailure)
+	if p.mode != RestartOnFailure || p.backoff != defaultRestartBackoff || p.maxBackoff != defaultRestartMaxBackoff {
+		t.Fatalf("unexpected default policy: %#v", p)
+	}
+
+	p = policyFromConfig(config.Restart{Policy: "Always", Backoff: "5s", MaxBackoff: "2s", MaxRetries: 3}, RestartNever)
+	if p.mode != RestartAlways || p.maxRetries != 3 {
+		t.Fatalf("unexpected policy: %#v", p)
+	}
+	if p.maxBackoff != 5*time.Second {
+		t.Fatalf("max backoff should be raised to backoff: %s", p.maxBackoff)
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	p := restartPolicy{backoff: time.Second, maxBackoff: 10 * time.Second}
+	expect := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, want := range expect {
+		if got := p.delay(i + 1); got != want {
+			t.Fatalf("delay(%d) = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	failure := errors.New("exit status 1")
+	cases := []struct {
+		mode string
+		err  error
+		want bool
+	}{
+		{RestartNever, failure, false},
+		{RestartOnFailure, failure, true},
+		{RestartOnFailure, nil, false},
+		{RestartAlways, nil, true},
+	}
+	for _, tc := range cases {
+		if got := (restartPolicy{mode: tc.mode}).shouldRestart(tc.err); got != tc.want {
+			t.Fatalf("mode=%s err=%v: got %t want %t", tc.mode, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRecordExitMaxRetriesAndStableReset(t *testing.T) {
+	p := &supervised{spec: processSpec{policy: restartPolicy{
+		mode: RestartAlways, maxRetries: 2, backoff: time.Second, maxBackoff: time.Minute,
+	}}}
+	now := time.Now()
+	if _, reason := p.recordExitLocked(nil, now, time.Second); reason != "" {
+		t.Fatalf("first exit should restart: %s", reason)
+	}
+	if d, reason := p.recordExitLocked(nil, now, time.Second); reason != "" || d != 2*time.Second {
+		t.Fatalf("second exit should back off 2s: %s %s", d, reason)
+	}
+	if _, reason := p.recordExitLocked(nil, now, stableRunDuration); reason != "" {
+		t.Fatalf("stable run should reset retries: %s", reason)
+	}
+	p.recordExitLocked(nil, now, time.Second)
+	if _, reason := p.recordExitLocked(nil, now, time.Second); reason == "" {
+		t.Fatal("expected max retries to be exceeded")
+	}
+}
+
+func TestRecordExitDetectsCrashLoop(t *testing.T) {
+	p := &supervised{spec: processSpec{policy: restartPolicy{
+		mode: RestartAlways, maxRetries: 10, backoff: time.Second, maxBackoff: time.Minute,
+	}}}
+	now := time.Now()
+	for i := 0; i < crashLoopThreshold-1; i++ {
+		if _, reason := p.recordExitLocked(nil, now, stableRunDuration); reason != "" {
+			t.Fatalf("exit %d should restart: %s", i, reason)
+		}
+	}
+	if _, reason := p.recordExitLocked(nil, now, stableRunDuration); reason == "" {
+		t.Fatal("expected crash loop detection")
+	}
+
+	old := now.Add(-2 * crashLoopWindow)
+	p = &supervised{spec: p.spec, exits: []time.Time{old, old, old, old}}
+	if _, reason := p.recordExitLocked(nil, now, stableRunDuration); reason != "" {
+		t.Fatalf("exits outside the window should not count: %s", reason)
+	}
+
+	p = &supervised{spec: processSpec{policy: restartPolicy{
+		mode: RestartAlways, backoff: time.Second, maxBackoff: time.Minute,
+	}}}
+	for i := 0; i < crashLoopThreshold-1; i++ {
+		p.recordExitLocked(nil, now, stableRunDuration)
+	}
+	delay, reason := p.recordExitLocked(nil, now, stableRunDuration)
+	if reason != "" || delay != crashLoopCooldown || len(p.exits) != 0 {
+		t.Fatalf("unlimited retries should cool down instead of giving up: delay=%s reason=%q", delay, reason)
+	}
+}
+
+func TestSupervisorRestartsUntilGivingUp(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	sup := newSupervisor(t.Logf)
+	defer sup.stopAll()
+	spec := processSpec{
+		key:  "failing",
+		name: "core failing",
+		bin:  sh,
+		args: []string{"-c", "exit 3"},
+		policy: restartPolicy{
+			mode: RestartOnFailure, maxRetries: 2, backoff: 10 * time.Millisecond, maxBackoff: 10 * time.Millisecond,
+		},
+	}
+	p, err := sup.launch(spec, nil)
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	select {
+	case ev := <-sup.gaveUp:
+		if ev.key != "failing" || ev.err == nil {
+			t.Fatalf("unexpected exit event: %#v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not give up")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.restarts != 2 || p.state != stateFailed {
+		t.Fatalf("unexpected process state: restarts=%d state=%s", p.restarts, p.state)
+	}
+}
+
+func TestStartAfterStopKillsNewProcess(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	p := &supervised{
+		spec:     processSpec{name: "core sleeper", bin: sh, args: []string{"-c", "sleep 30"}},
+		stopCh:   make(chan struct{}),
+		exited:   make(chan struct{}),
+		stopping: true,
+	}
+	if err := p.start(); !errors.Is(err, errStopping) {
+		t.Fatalf("expected errStopping, got %v", err)
+	}
+	select {
+	case <-p.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process started during stop was not killed")
+	}
+}
+
+func TestLaunchRejectsLiveDuplicateKey(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	sup := newSupervisor(t.Logf)
+	defer sup.stopAll()
+	spec := processSpec{key: "naive", name: "core naive", bin: sh, args: []string{"-c", "sleep 30"}}
+	first, err := sup.launch(spec, nil)
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	if _, err := sup.launch(spec, nil); err == nil {
+		t.Fatal("expected error for a second process with a live key")
+	}
+	if p, _ := sup.get("naive"); p != first {
+		t.Fatal("the running process must stay registered")
+	}
+}
@@ -0,0 +1,187 @@
+// Package share turns the LAN-reachable inbounds of an xray config into proxy
+// URIs and QR codes for the terminal.
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// QR styles accepted by WriteQR.
+const (
+	QRUnicode = "unicode"
+	QRASCII   = "ascii"
+	QRNone    = "none"
+)
+
+// quietZone is the light border, in modules, around a printed code.
+const quietZone = 2
+
+// Link is one proxy URI for a LAN client.
+type Link struct {
+	Tag string
+	URI string
+}
+
+// interfaceAddrs is replaced in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// Links returns a URI for every socks, http and mixed inbound of xrayConfig
+// on every non-loopback address it can be reached at. The first account of
+// an inbound, if any, is included.
+func Links(xrayConfig string) ([]Link, error) {
+	b, err := os.ReadFile(xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read xray config: %w", err)
+	}
+	var doc struct {
+		Inbounds []struct {
+			Tag      string `json:"tag"`
+			Protocol string `json:"protocol"`
+			Listen   string `json:"listen"`
+			Port     int    `json:"port"`
+			Settings struct {
+				Accounts []struct {
+					User string `json:"user"`
+					Pass string `json:"pass"`
+				} `json:"accounts"`
+			} `json:"settings"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+
+	var links []Link
+	for _, in := range doc.Inbounds {
+		var schemes []string
+		switch strings.ToLower(in.Protocol) {
+		case "socks":
+			schemes = []string{"socks5"}
+		case "http":
+			schemes = []string{"http"}
+		case "mixed":
+			schemes = []string{"socks5", "http"}
+		default:
+			continue
+		}
+		if in.Port <= 0 {
+			continue
+		}
+		var user *url.Userinfo
+		if accounts := in.Settings.Accounts; len(accounts) > 0 {
+			user = url.UserPassword(accounts[0].User, accounts[0].Pass)
+		}
+		for _, host := range Hosts(in.Listen) {
+			for _, scheme := range schemes {
+				u := url.URL{Scheme: scheme, User: user, Host: net.JoinHostPort(host, strconv.Itoa(in.Port))}
+				links = append(links, Link{Tag: in.Tag, URI: u.String()})
+			}
+		}
+	}
+	return links, nil
+}
+
+// Hosts returns the non-loopback addresses a listener on listen accepts
+// connections on: the interface addresses for a wildcard (IPv4 only for
+// 0.0.0.0), the address itself otherwise, and nothing for loopback.
+func Hosts(listen string) []string {
+	listen = strings.Trim(strings.TrimSpace(listen), "[]")
+	if listen != "" && listen != "0.0.0.0" && listen != "::" {
+		if ip := net.ParseIP(listen); strings.EqualFold(listen, "localhost") || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+		return []string{listen}
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if listen != "::" && ipNet.IP.To4() == nil {
+			continue
+		}
+		out = append(out, ipNet.IP.String())
+	}
+	return out
+}
+
+// WriteQR prints text as a QR code. The unicode style packs two rows per
+// line and draws light modules, which suits dark terminals; the ascii style
+// draws dark modules as "##" for light backgrounds and plain-text logs.
+func WriteQR(w io.Writer, text, style string) error {
+	if style == QRNone {
+		return nil
+	}
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return fmt.Errorf("encode qr code: %w", err)
+	}
+	var b strings.Builder
+	lo, hi := -quietZone, code.Size+quietZone
+	switch style {
+	case QRUnicode:
+		for y := lo; y < hi; y += 2 {
+			for x := lo; x < hi; x++ {
+				upper := !code.Black(x, y)
+				lower := y+1 < hi && !code.Black(x, y+1)
+				switch {
+				case upper && lower:
+					b.WriteString("█")
+				case upper:
+					b.WriteString("▀")
+				case lower:
+					b.WriteString("▄")
+				default:
+					b.WriteString(" ")
+				}
+			}
+			b.WriteString("\n")
+		}
+	case QRASCII:
+		for y := lo; y < hi; y++ {
+			for x := lo; x < hi; x++ {
+				if code.Black(x, y) {
+					b.WriteString("##")
+				} else {
+					b.WriteString("  ")
+				}
+			}
+			b.WriteString("\n")
+		}
+	default:
+		return fmt.Errorf("unknown qr style %q (want %s, %s or %s)", style, QRUnicode, QRASCII, QRNone)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// Print writes every link followed by its QR code.
+func Print(w io.Writer, links []Link, style string) error {
+	for _, l := range links {
+		label := l.URI
+		if l.Tag != "" {
+			label = fmt.Sprintf("%s  (%s)", l.URI, l.Tag)
+		}
+		if _, err := fmt.Fprintln(w, label); err != nil {
+			return err
+		}
+		if err := WriteQR(w, l.URI, style); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,8 +18,29 @@ const (
 type File struct {
 	Version    int         `json:"version"`
 	V2rayNHome string      `json:"v2rayn_home"`
+	ConfigFile string      `json:"config_file,omitempty"`
 	ParsedAt   time.Time   `json:"parsed_at"`
 	Config     config.File `json:"config"`
+	Rules      RuleCounts  `json:"rules"`
+	Options    Options     `json:"options"`
+}
+
+// RuleCounts records how many leading rules of the generated routing table
+// pin the per-core and via inbounds, then came from custom_rules.yaml and from the
+// imported routing; the remaining rules come from the xray base config,
+// except the last one when Fallback marks it as the generated catch-all.
+type RuleCounts struct {
+	CoreInbounds int  `json:"core_inbounds,omitempty"`
+	Custom       int  `json:"custom"`
+	Imported     int  `json:"imported"`
+	Fallback     bool `json:"fallback,omitempty"`
+}
+
+// Options records the parse flags that override the source config, so a
+// reload re-parses the same way.
+type Options struct {
+	CoreInbounds *config.CoreInbounds `json:"core_inbounds,omitempty"`
+	Mode         string               `json:"mode,omitempty"`
 }
 
 func New(v2raynHome string, cfg *config.File) *File {
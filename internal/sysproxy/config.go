@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
 const RequiredBypassList = "localhost;127.*;10.*;172.16.*;172.17.*;172.18.*;172.19.*;172.20.*;172.21.*;172.22.*;172.23.*;172.24.*;172.25.*;172.26.*;172.27.*;172.28.*;172.29.*;172.30.*;172.31.*;192.168.*;127.0.0.1"
@@ -13,6 +16,14 @@ type InboundEndpoint struct {
 	Protocol string
 	Host     string
 	Port     int
+	Tag      string
+	// AllInterfaces is set when the inbound listens on a wildcard address;
+	// Host is then the loopback address.
+	AllInterfaces bool
+	// Username and Password are the first account of an inbound that
+	// requires one.
+	Username string
+	Password string
 }
 
 type xrayConfig struct {
@@ -24,6 +35,12 @@ type xrayInbound struct {
 	Protocol string `json:"protocol"`
 	Listen   string `json:"listen"`
 	Port     int    `json:"port"`
+	Settings struct {
+		Accounts []struct {
+			User string `json:"user"`
+			Pass string `json:"pass"`
+		} `json:"accounts"`
+	} `json:"settings"`
 }
 
 func DetectProxyEndpoint(xrayConfigPath string) (*InboundEndpoint, error) {
@@ -46,30 +63,43 @@ func DetectProxyEndpoint(xrayConfigPath string) (*InboundEndpoint, error) {
 			if !strings.EqualFold(strings.TrimSpace(inbound.Protocol), protocol) {
 				continue
 			}
-			if inbound.Port <= 0 {
+			if inbound.Port <= 0 || isCoreInbound(inbound) {
 				continue
 			}
-			return &InboundEndpoint{
-				Protocol: protocol,
-				Host:     normalizeHost(inbound.Listen),
-				Port:     inbound.Port,
-			}, nil
+			return newEndpoint(protocol, inbound), nil
 		}
 	}
 
 	for _, inbound := range cfg.Inbounds {
-		if inbound.Port <= 0 {
+		if inbound.Port <= 0 || isCoreInbound(inbound) {
 			continue
 		}
-		return &InboundEndpoint{
-			Protocol: strings.ToLower(strings.TrimSpace(inbound.Protocol)),
-			Host:     normalizeHost(inbound.Listen),
-			Port:     inbound.Port,
-		}, nil
+		return newEndpoint(strings.ToLower(strings.TrimSpace(inbound.Protocol)), inbound), nil
 	}
 	return nil, fmt.Errorf("xray config has no valid inbound endpoint")
 }
 
+// isCoreInbound reports a generated per-core or via inbound, which exits
+// through one fixed outbound and so must not become the system proxy.
+func isCoreInbound(inbound xrayInbound) bool {
+	return strings.HasPrefix(inbound.Tag, config.CoreInboundTagPrefix) || strings.HasPrefix(inbound.Tag, config.ViaInboundTagPrefix)
+}
+
+func newEndpoint(protocol string, inbound xrayInbound) *InboundEndpoint {
+	host := normalizeHost(inbound.Listen)
+	ep := &InboundEndpoint{
+		Protocol:      protocol,
+		Host:          host,
+		Port:          inbound.Port,
+		Tag:           inbound.Tag,
+		AllInterfaces: host != strings.TrimSpace(inbound.Listen),
+	}
+	if accounts := inbound.Settings.Accounts; len(accounts) > 0 {
+		ep.Username, ep.Password = accounts[0].User, accounts[0].Pass
+	}
+	return ep
+}
+
 func normalizeHost(host string) string {
 	host = strings.TrimSpace(host)
 	switch host {
@@ -99,6 +129,40 @@ func mergeBypass(existing, required string) string {
 	return strings.Join(out, ";")
 }
 
+// bypassHosts converts a Windows-style bypass list into host entries for
+// desktops and tools that do not understand "10.*": dotted IP wildcards
+// become CIDR ranges, other entries are kept as they are.
+func bypassHosts(list string) []string {
+	items := splitBypass(list)
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, wildcardToCIDR(item))
+	}
+	return out
+}
+
+func wildcardToCIDR(entry string) string {
+	prefix, ok := strings.CutSuffix(entry, ".*")
+	if !ok {
+		return entry
+	}
+	octets := strings.Split(prefix, ".")
+	if len(octets) > 3 {
+		return entry
+	}
+	for _, o := range octets {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 || n > 255 {
+			return entry
+		}
+	}
+	bits := len(octets) * 8
+	for len(octets) < 4 {
+		octets = append(octets, "0")
+	}
+	return fmt.Sprintf("%s/%d", strings.Join(octets, "."), bits)
+}
+
 func splitBypass(v string) []string {
 	f := func(r rune) bool {
 		return r == ';' || r == ','
@@ -25,11 +25,32 @@ func TestDetectProxyEndpoint(t *testing.T) {
 	if err != nil {
 		t.Fatalf("detect endpoint: %v", err)
 	}
-	if ep.Protocol != "http" || ep.Host != "127.0.0.1" || ep.Port != 10809 {
+	if ep.Protocol != "http" || ep.Host != "127.0.0.1" || ep.Port != 10809 || ep.Tag != "in-http" || !ep.AllInterfaces {
 		t.Fatalf("unexpected endpoint: %#v", ep)
 	}
 }
 
+func TestDetectProxyEndpointSkipsCoreInbounds(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "xray.generated.json")
+	content := `{
+  "inbounds": [
+    {"tag":"in-socks","protocol":"socks","listen":"127.0.0.1","port":10808},
+    {"tag":"core-in-naive-a","protocol":"mixed","listen":"127.0.0.1","port":20001}
+  ]
+}`
+	if err := os.WriteFile(cfgPath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := DetectProxyEndpoint(cfgPath)
+	if err != nil {
+		t.Fatalf("detect endpoint: %v", err)
+	}
+	if ep.Tag != "in-socks" || ep.Port != 10808 {
+		t.Fatalf("core inbound should not be picked: %#v", ep)
+	}
+}
+
 func TestMergeBypass(t *testing.T) {
 	existing := "localhost;example.com;127.*"
 	required := "localhost;10.*;127.*;192.168.*"
@@ -59,3 +80,11 @@ func sortedLowerSet(v string) []string {
 	sort.Strings(out)
 	return out
 }
+
+func TestBypassHosts(t *testing.T) {
+	got := bypassHosts("localhost;127.*;172.16.*;10.1.2.*;*.example.com;300.*")
+	want := []string{"localhost", "127.0.0.0/8", "172.16.0.0/16", "10.1.2.0/24", "*.example.com", "300.*"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
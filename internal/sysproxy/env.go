@@ -0,0 +1,120 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// EnvVar is one proxy environment variable.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// EnvFormats lists the formats accepted by FormatEnv.
+var EnvFormats = []string{"sh", "fish", "powershell", "systemd", "docker"}
+
+// ProxyEnv returns http_proxy, https_proxy, all_proxy and no_proxy (plus
+// their upper-case forms) pointing at ep, with its account as userinfo.
+func ProxyEnv(ep *InboundEndpoint) []EnvVar {
+	proxyURL := func(scheme string) string {
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port))}
+		if ep.Username != "" {
+			u.User = url.UserPassword(ep.Username, ep.Password)
+		}
+		return u.String()
+	}
+	httpURL := proxyURL("http")
+	allURL := httpURL
+	switch ep.Protocol {
+	case "socks":
+		httpURL = proxyURL("socks5h")
+		allURL = httpURL
+	case "mixed":
+		allURL = proxyURL("socks5h")
+	}
+	noProxy := NoProxy(RequiredBypassList)
+
+	var out []EnvVar
+	for _, v := range []EnvVar{
+		{"http_proxy", httpURL},
+		{"https_proxy", httpURL},
+		{"all_proxy", allURL},
+		{"no_proxy", noProxy},
+	} {
+		out = append(out, v, EnvVar{Name: strings.ToUpper(v.Name), Value: v.Value})
+	}
+	return out
+}
+
+// NoProxy converts a Windows-style bypass list into no_proxy syntax: IP
+// wildcards become CIDR ranges, "*.example.com" becomes ".example.com" and
+// "<local>" is dropped.
+func NoProxy(bypass string) string {
+	var out []string
+	for _, h := range bypassHosts(bypass) {
+		if strings.EqualFold(h, "<local>") {
+			continue
+		}
+		out = append(out, strings.TrimPrefix(h, "*"))
+	}
+	return strings.Join(out, ",")
+}
+
+// FormatEnv renders vars for a shell or service manager; see EnvFormats.
+func FormatEnv(format string, vars []EnvVar) (string, error) {
+	var b strings.Builder
+	switch format {
+	case "sh", "bash", "zsh":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "export %s=%s\n", v.Name, shellQuote(v.Value))
+		}
+	case "fish":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "set -gx %s %s\n", v.Name, fishQuote(v.Value))
+		}
+	case "powershell", "pwsh":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "$env:%s = '%s'\n", v.Name, strings.ReplaceAll(v.Value, "'", "''"))
+		}
+	case "systemd":
+		b.WriteString("[Service]\n")
+		for _, v := range vars {
+			fmt.Fprintf(&b, "Environment=%q\n", v.Name+"="+v.Value)
+		}
+	case "docker":
+		values := map[string]string{}
+		for _, v := range vars {
+			switch v.Name {
+			case "http_proxy":
+				values["http-proxy"] = v.Value
+			case "https_proxy":
+				values["https-proxy"] = v.Value
+			case "no_proxy":
+				values["no-proxy"] = v.Value
+			}
+		}
+		out, err := json.MarshalIndent(map[string]any{"proxies": values}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		b.Write(out)
+		b.WriteString("\n")
+	default:
+		return "", fmt.Errorf("unknown env format %q (want one of %s)", format, strings.Join(EnvFormats, ", "))
+	}
+	return b.String(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
@@ -0,0 +1,137 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	gnomeBackendName = "gnome"
+	gnomeProxySchema = "org.gnome.system.proxy"
+)
+
+// gnomeKeys are the settings captured in a snapshot, as "<sub-schema>.<key>"
+// relative to org.gnome.system.proxy. Values are kept in GVariant text form
+// so they can be passed back to "gsettings set" unchanged.
+var gnomeKeys = []string{
+	"mode", "autoconfig-url", "ignore-hosts",
+	"http.host", "http.port", "https.host", "https.port", "socks.host", "socks.port",
+}
+
+// gnomeBackend manages the GNOME proxy settings through gsettings.
+type gnomeBackend struct {
+	run commandRunner
+}
+
+func (gnomeBackend) Name() string { return gnomeBackendName }
+
+func (b gnomeBackend) Snapshot() (map[string]string, error) {
+	snap := make(map[string]string, len(gnomeKeys))
+	for _, k := range gnomeKeys {
+		schema, key := gnomeSchemaKey(k)
+		v, err := b.run("gsettings", "get", schema, key)
+		if err != nil {
+			return nil, err
+		}
+		snap[k] = v
+	}
+	return snap, nil
+}
+
+func (gnomeBackend) Configured(snap map[string]string) bool {
+	return unquoteGVariant(snap["mode"]) != "none"
+}
+
+func (b gnomeBackend) Apply(snap map[string]string, ep *InboundEndpoint) error {
+	host := quoteGVariant(ep.Host)
+	port := strconv.Itoa(ep.Port)
+	// Protocols the endpoint does not serve are cleared so stale entries
+	// from an earlier proxy are not used alongside it.
+	values := map[string]string{
+		"http.host": "''", "http.port": "0",
+		"https.host": "''", "https.port": "0",
+		"socks.host": "''", "socks.port": "0",
+	}
+	if ep.Protocol != "socks" {
+		values["http.host"], values["http.port"] = host, port
+		values["https.host"], values["https.port"] = host, port
+	}
+	if ep.Protocol == "socks" || ep.Protocol == "mixed" {
+		values["socks.host"], values["socks.port"] = host, port
+	}
+	existing := strings.Join(parseGVariantStrings(snap["ignore-hosts"]), ";")
+	values["ignore-hosts"] = formatGVariantStrings(bypassHosts(mergeBypass(existing, RequiredBypassList)))
+	values["mode"] = quoteGVariant("manual")
+
+	// mode goes last so the proxy is only switched on once it is complete.
+	for _, k := range gnomeKeys {
+		if v, ok := values[k]; ok && k != "mode" {
+			if err := b.set(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return b.set("mode", values["mode"])
+}
+
+func (b gnomeBackend) Restore(snap map[string]string) error {
+	for _, k := range gnomeKeys {
+		if k == "mode" {
+			continue
+		}
+		if v, ok := snap[k]; ok {
+			if err := b.set(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	mode, ok := snap["mode"]
+	if !ok {
+		return fmt.Errorf("gnome snapshot has no mode")
+	}
+	return b.set("mode", mode)
+}
+
+func (b gnomeBackend) set(k, value string) error {
+	schema, key := gnomeSchemaKey(k)
+	_, err := b.run("gsettings", "set", schema, key, value)
+	return err
+}
+
+func gnomeSchemaKey(k string) (string, string) {
+	if sub, key, ok := strings.Cut(k, "."); ok {
+		return gnomeProxySchema + "." + sub, key
+	}
+	return gnomeProxySchema, k
+}
+
+func quoteGVariant(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func unquoteGVariant(s string) string {
+	return strings.Trim(strings.TrimSpace(s), "'")
+}
+
+// parseGVariantStrings reads a string array such as "['localhost', '::1']"
+// or "@as []".
+func parseGVariantStrings(s string) []string {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "@as"))
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if v := unquoteGVariant(part); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func formatGVariantStrings(items []string) string {
+	quoted := make([]string, len(items))
+	for i, v := range items {
+		quoted[i] = quoteGVariant(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
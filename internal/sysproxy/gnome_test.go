@@ -0,0 +1,137 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeGSettings stands in for the gsettings binary.
+type fakeGSettings struct {
+	values map[string]string
+	sets   []string
+}
+
+func (f *fakeGSettings) run(name string, args ...string) (string, error) {
+	if name != "gsettings" || len(args) < 3 {
+		return "", fmt.Errorf("unexpected command %s %v", name, args)
+	}
+	key := args[1] + " " + args[2]
+	switch args[0] {
+	case "get":
+		v, ok := f.values[key]
+		if !ok {
+			return "", fmt.Errorf("no such key %s", key)
+		}
+		return v, nil
+	case "set":
+		f.values[key] = args[3]
+		f.sets = append(f.sets, args[2])
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected gsettings action %s", args[0])
+}
+
+func newFakeGSettings(mode string) *fakeGSettings {
+	return &fakeGSettings{values: map[string]string{
+		"org.gnome.system.proxy mode":           mode,
+		"org.gnome.system.proxy autoconfig-url": "''",
+		"org.gnome.system.proxy ignore-hosts":   "['localhost', '127.0.0.0/8', '::1', 'corp.example']",
+		"org.gnome.system.proxy.http host":      "''",
+		"org.gnome.system.proxy.http port":      "0",
+		"org.gnome.system.proxy.https host":     "''",
+		"org.gnome.system.proxy.https port":     "0",
+		"org.gnome.system.proxy.socks host":     "''",
+		"org.gnome.system.proxy.socks port":     "0",
+	}}
+}
+
+func TestGnomeBackendApplyAndRestore(t *testing.T) {
+	fake := newFakeGSettings("'none'")
+	b := gnomeBackend{run: fake.run}
+
+	snap, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if b.Configured(snap) {
+		t.Fatal("mode none should not count as configured")
+	}
+	if err := b.Apply(snap, &InboundEndpoint{Protocol: "mixed", Host: "127.0.0.1", Port: 10808}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if fake.sets[len(fake.sets)-1] != "mode" {
+		t.Fatalf("mode must be switched last: %v", fake.sets)
+	}
+	if fake.values["org.gnome.system.proxy mode"] != "'manual'" ||
+		fake.values["org.gnome.system.proxy.http host"] != "'127.0.0.1'" ||
+		fake.values["org.gnome.system.proxy.socks port"] != "10808" {
+		t.Fatalf("unexpected applied settings: %#v", fake.values)
+	}
+	ignore := parseGVariantStrings(fake.values["org.gnome.system.proxy ignore-hosts"])
+	joined := strings.Join(ignore, " ")
+	for _, want := range []string{"corp.example", "::1", "10.0.0.0/8", "192.168.0.0/16", "172.31.0.0/16"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("ignore-hosts misses %s: %v", want, ignore)
+		}
+	}
+
+	if err := b.Restore(snap); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	orig := newFakeGSettings("'none'")
+	for k, v := range orig.values {
+		if fake.values[k] != v {
+			t.Fatalf("%s not restored: got %s, want %s", k, fake.values[k], v)
+		}
+	}
+}
+
+func TestGnomeBackendApplyClearsUnusedProtocols(t *testing.T) {
+	fake := newFakeGSettings("'manual'")
+	fake.values["org.gnome.system.proxy.http host"] = "'10.0.0.1'"
+	fake.values["org.gnome.system.proxy.http port"] = "3128"
+	b := gnomeBackend{run: fake.run}
+
+	snap, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if err := b.Apply(snap, &InboundEndpoint{Protocol: "socks", Host: "127.0.0.1", Port: 10808}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if fake.values["org.gnome.system.proxy.http host"] != "''" || fake.values["org.gnome.system.proxy.http port"] != "0" ||
+		fake.values["org.gnome.system.proxy.socks host"] != "'127.0.0.1'" {
+		t.Fatalf("stale http proxy should be cleared: %#v", fake.values)
+	}
+	if err := b.Restore(snap); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if fake.values["org.gnome.system.proxy.http host"] != "'10.0.0.1'" || fake.values["org.gnome.system.proxy.http port"] != "3128" {
+		t.Fatalf("http proxy not restored: %#v", fake.values)
+	}
+}
+
+func TestGnomeBackendConfigured(t *testing.T) {
+	b := gnomeBackend{run: newFakeGSettings("'auto'").run}
+	snap, err := b.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Configured(snap) {
+		t.Fatal("PAC mode should count as configured")
+	}
+}
+
+func TestParseGVariantStrings(t *testing.T) {
+	if got := parseGVariantStrings("@as []"); len(got) != 0 {
+		t.Fatalf("empty array: %#v", got)
+	}
+	got := parseGVariantStrings("['localhost', '127.0.0.0/8']")
+	if len(got) != 2 || got[1] != "127.0.0.0/8" {
+		t.Fatalf("unexpected parse: %#v", got)
+	}
+	if formatGVariantStrings(got) != "['localhost', '127.0.0.0/8']" {
+		t.Fatalf("unexpected format: %s", formatGVariantStrings(got))
+	}
+}
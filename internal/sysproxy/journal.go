@@ -0,0 +1,157 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JournalFileName holds the settings to restore while this program owns the
+// system proxy. It is written before anything is changed and removed after a
+// successful restore, so a leftover file means a session did not exit cleanly.
+const JournalFileName = "sysproxy.journal.json"
+
+// Backend reads and writes the system proxy settings of one platform or
+// desktop environment. Snapshots are flat string maps so they can be
+// journaled without knowing the backend.
+type Backend interface {
+	Name() string
+	Snapshot() (map[string]string, error)
+	// Configured reports whether snap already has a proxy (manual or PAC)
+	// that must not be touched.
+	Configured(snap map[string]string) bool
+	// Apply points the system proxy at ep, keeping the bypass entries of snap.
+	Apply(snap map[string]string, ep *InboundEndpoint) error
+	Restore(snap map[string]string) error
+}
+
+type journal struct {
+	Backend   string            `json:"backend"`
+	PID       int               `json:"pid"`
+	CreatedAt time.Time         `json:"created_at"`
+	Snapshot  map[string]string `json:"snapshot"`
+}
+
+// ErrJournalOwned is returned when the journal belongs to a process that is
+// still running.
+var ErrJournalOwned = errors.New("system proxy is owned by a running session")
+
+// Platform hooks, replaced in tests.
+var (
+	detectBackend = detectPlatformBackend
+	lookupBackend = platformBackend
+	processAlive  = ProcessAlive
+)
+
+// JournalPath returns the journal location in dir.
+func JournalPath(dir string) string {
+	return filepath.Join(dir, JournalFileName)
+}
+
+// ConfigureForRun points the system proxy at the xray inbound unless a proxy
+// is already configured. The previous settings are journaled to journalDir
+// first. It returns a restore function and whether anything was changed.
+func ConfigureForRun(journalDir, xrayConfigPath string) (func() error, bool, error) {
+	backend := detectBackend()
+	if backend == nil {
+		return func() error { return nil }, false, nil
+	}
+	return configureWith(backend, journalDir, xrayConfigPath)
+}
+
+func configureWith(backend Backend, journalDir, xrayConfigPath string) (func() error, bool, error) {
+	noop := func() error { return nil }
+	snap, err := backend.Snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+	if backend.Configured(snap) {
+		return noop, false, nil
+	}
+	endpoint, err := DetectProxyEndpoint(xrayConfigPath)
+	if err != nil {
+		return nil, false, err
+	}
+	// OS proxy settings cannot carry an account, so an authenticated
+	// inbound would only produce failing requests.
+	if endpoint.Username != "" {
+		return noop, false, nil
+	}
+
+	j := &journal{Backend: backend.Name(), PID: os.Getpid(), CreatedAt: time.Now().UTC(), Snapshot: snap}
+	if err := writeJournal(journalDir, j); err != nil {
+		return nil, false, err
+	}
+	if err := backend.Apply(snap, endpoint); err != nil {
+		if rerr := backend.Restore(snap); rerr == nil {
+			_ = os.Remove(JournalPath(journalDir))
+		}
+		return nil, false, err
+	}
+
+	restore := func() error {
+		if err := backend.Restore(snap); err != nil {
+			return err
+		}
+		if err := os.Remove(JournalPath(journalDir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove sysproxy journal: %w", err)
+		}
+		return nil
+	}
+	return restore, true, nil
+}
+
+// RestoreJournal restores the settings recorded by a session that did not
+// exit cleanly. It reports whether a journal was found and replayed. Unless
+// force is set, a journal whose process is still running is left alone and
+// ErrJournalOwned is returned.
+func RestoreJournal(journalDir string, force bool) (bool, error) {
+	path := JournalPath(journalDir)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read sysproxy journal: %w", err)
+	}
+	var j journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return false, fmt.Errorf("parse sysproxy journal: %w", err)
+	}
+	if !force && j.PID != os.Getpid() && processAlive(j.PID) {
+		return false, fmt.Errorf("%w (pid %d)", ErrJournalOwned, j.PID)
+	}
+	backend := lookupBackend(j.Backend)
+	if backend == nil {
+		return false, fmt.Errorf("sysproxy journal uses backend %q, not available on this system", j.Backend)
+	}
+	if err := backend.Restore(j.Snapshot); err != nil {
+		return false, fmt.Errorf("restore %s proxy settings: %w", j.Backend, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return true, fmt.Errorf("remove sysproxy journal: %w", err)
+	}
+	return true, nil
+}
+
+func writeJournal(dir string, j *journal) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create journal dir: %w", err)
+	}
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal sysproxy journal: %w", err)
+	}
+	target := JournalPath(dir)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write sysproxy journal: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("rename sysproxy journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package sysproxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeBackend struct {
+	current  map[string]string
+	applied  int
+	restored int
+	failNext bool
+}
+
+func (f *fakeBackend) Name() string { return "fake" }
+
+func (f *fakeBackend) Snapshot() (map[string]string, error) {
+	out := make(map[string]string, len(f.current))
+	for k, v := range f.current {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (f *fakeBackend) Configured(snap map[string]string) bool {
+	return snap["mode"] != "none"
+}
+
+func (f *fakeBackend) Apply(snap map[string]string, ep *InboundEndpoint) error {
+	if f.failNext {
+		f.failNext = false
+		return errors.New("apply failed")
+	}
+	f.applied++
+	f.current = map[string]string{"mode": "manual", "bypass": mergeBypass(snap["bypass"], RequiredBypassList)}
+	return nil
+}
+
+func (f *fakeBackend) Restore(snap map[string]string) error {
+	f.restored++
+	f.current = snap
+	return nil
+}
+
+func writeXrayConfig(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "xray.generated.json")
+	if err := os.WriteFile(path, []byte(`{"inbounds":[{"protocol":"http","listen":"127.0.0.1","port":10809}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func useFakeBackend(t *testing.T, b *fakeBackend, alive bool) {
+	t.Helper()
+	oldDetect, oldLookup, oldAlive := detectBackend, lookupBackend, processAlive
+	detectBackend = func() Backend { return b }
+	lookupBackend = func(name string) Backend {
+		if name == b.Name() {
+			return b
+		}
+		return nil
+	}
+	processAlive = func(int) bool { return alive }
+	t.Cleanup(func() { detectBackend, lookupBackend, processAlive = oldDetect, oldLookup, oldAlive })
+}
+
+func TestConfigureForRunJournalsAndRestores(t *testing.T) {
+	dir := t.TempDir()
+	b := &fakeBackend{current: map[string]string{"mode": "none", "bypass": "example.com"}}
+	useFakeBackend(t, b, false)
+
+	restore, changed, err := ConfigureForRun(dir, writeXrayConfig(t, dir))
+	if err != nil || !changed {
+		t.Fatalf("configure: changed=%t err=%v", changed, err)
+	}
+	if _, err := os.Stat(JournalPath(dir)); err != nil {
+		t.Fatalf("journal should exist while the proxy is set: %v", err)
+	}
+	if err := restore(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if b.current["mode"] != "none" || b.current["bypass"] != "example.com" {
+		t.Fatalf("settings not restored: %#v", b.current)
+	}
+	if _, err := os.Stat(JournalPath(dir)); !os.IsNotExist(err) {
+		t.Fatal("journal should be removed after restore")
+	}
+}
+
+func TestConfigureForRunLeavesExistingProxy(t *testing.T) {
+	dir := t.TempDir()
+	b := &fakeBackend{current: map[string]string{"mode": "auto"}}
+	useFakeBackend(t, b, false)
+
+	_, changed, err := ConfigureForRun(dir, writeXrayConfig(t, dir))
+	if err != nil || changed || b.applied != 0 {
+		t.Fatalf("configured proxy must not be touched: changed=%t applied=%d err=%v", changed, b.applied, err)
+	}
+	if _, err := os.Stat(JournalPath(dir)); !os.IsNotExist(err) {
+		t.Fatal("no journal expected when nothing changed")
+	}
+}
+
+func TestConfigureForRunSkipsAuthenticatedInbound(t *testing.T) {
+	dir := t.TempDir()
+	b := &fakeBackend{current: map[string]string{"mode": "none"}}
+	useFakeBackend(t, b, false)
+	path := filepath.Join(dir, "xray.generated.json")
+	if err := os.WriteFile(path, []byte(`{"inbounds":[{"protocol":"http","listen":"0.0.0.0","port":10809,"settings":{"accounts":[{"user":"u","pass":"p"}]}}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, changed, err := ConfigureForRun(dir, path)
+	if err != nil || changed || b.applied != 0 {
+		t.Fatalf("authenticated inbound must not become the system proxy: changed=%t applied=%d err=%v", changed, b.applied, err)
+	}
+}
+
+func TestConfigureForRunRollsBackFailedApply(t *testing.T) {
+	dir := t.TempDir()
+	b := &fakeBackend{current: map[string]string{"mode": "none"}, failNext: true}
+	useFakeBackend(t, b, false)
+
+	if _, _, err := ConfigureForRun(dir, writeXrayConfig(t, dir)); err == nil {
+		t.Fatal("expected apply error")
+	}
+	if b.restored != 1 {
+		t.Fatalf("failed apply should restore the snapshot, restored=%d", b.restored)
+	}
+	if _, err := os.Stat(JournalPath(dir)); !os.IsNotExist(err) {
+		t.Fatal("journal should be removed after rollback")
+	}
+}
+
+func TestRestoreJournalAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	b := &fakeBackend{current: map[string]string{"mode": "none", "bypass": "corp.local"}}
+	useFakeBackend(t, b, true)
+
+	// Simulate a killed session: the proxy was applied, restore never ran.
+	if _, _, err := configureWith(b, dir, writeXrayConfig(t, dir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(JournalPath(dir), []byte(`{"backend":"fake","pid":999999,"snapshot":{"mode":"none","bypass":"corp.local"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RestoreJournal(dir, false); !errors.Is(err, ErrJournalOwned) {
+		t.Fatalf("journal of a live process should be left alone, got %v", err)
+	}
+	restored, err := RestoreJournal(dir, true)
+	if err != nil || !restored {
+		t.Fatalf("forced restore: restored=%t err=%v", restored, err)
+	}
+	if b.current["mode"] != "none" || b.current["bypass"] != "corp.local" {
+		t.Fatalf("settings not restored: %#v", b.current)
+	}
+	restored, err = RestoreJournal(dir, false)
+	if err != nil || restored {
+		t.Fatalf("second restore should find nothing: restored=%t err=%v", restored, err)
+	}
+}
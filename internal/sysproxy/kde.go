@@ -0,0 +1,101 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	kdeBackendName = "kde"
+	kdeConfigFile  = "kioslaverc"
+	kdeProxyGroup  = "Proxy Settings"
+)
+
+// kdeKeys are the kioslaverc entries captured in a snapshot. ProxyType is
+// 0 for none, 1 manual, 2 PAC script, 3 WPAD and 4 environment variables.
+var kdeKeys = []string{"ProxyType", "httpProxy", "httpsProxy", "socksProxy", "NoProxyFor", "Proxy Config Script"}
+
+// kdeBackend manages the KDE proxy settings through kreadconfig/kwriteconfig
+// (version 5 or 6, whichever is installed).
+type kdeBackend struct {
+	run     commandRunner
+	version string
+}
+
+func (kdeBackend) Name() string { return kdeBackendName }
+
+func (b kdeBackend) Snapshot() (map[string]string, error) {
+	snap := make(map[string]string, len(kdeKeys))
+	for _, k := range kdeKeys {
+		v, err := b.run("kreadconfig"+b.version, "--file", kdeConfigFile, "--group", kdeProxyGroup, "--key", k)
+		if err != nil {
+			return nil, err
+		}
+		snap[k] = v
+	}
+	return snap, nil
+}
+
+func (kdeBackend) Configured(snap map[string]string) bool {
+	t := strings.TrimSpace(snap["ProxyType"])
+	return t != "" && t != "0"
+}
+
+func (b kdeBackend) Apply(snap map[string]string, ep *InboundEndpoint) error {
+	// Empty values delete stale entries for protocols the endpoint does not
+	// serve.
+	values := map[string]string{"httpProxy": "", "httpsProxy": "", "socksProxy": ""}
+	if ep.Protocol != "socks" {
+		values["httpProxy"] = fmt.Sprintf("http://%s %d", ep.Host, ep.Port)
+		values["httpsProxy"] = values["httpProxy"]
+	}
+	if ep.Protocol == "socks" || ep.Protocol == "mixed" {
+		values["socksProxy"] = fmt.Sprintf("socks://%s %d", ep.Host, ep.Port)
+	}
+	values["NoProxyFor"] = strings.Join(bypassHosts(mergeBypass(snap["NoProxyFor"], RequiredBypassList)), ",")
+	for _, k := range kdeKeys {
+		if v, ok := values[k]; ok {
+			if err := b.write(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	if err := b.write("ProxyType", "1"); err != nil {
+		return err
+	}
+	b.notify()
+	return nil
+}
+
+func (b kdeBackend) Restore(snap map[string]string) error {
+	for _, k := range kdeKeys {
+		v, ok := snap[k]
+		if !ok {
+			continue
+		}
+		if err := b.write(k, v); err != nil {
+			return err
+		}
+	}
+	b.notify()
+	return nil
+}
+
+// write sets key, deleting it when value is empty so restored files match
+// the original instead of gaining empty entries.
+func (b kdeBackend) write(key, value string) error {
+	args := []string{"--file", kdeConfigFile, "--group", kdeProxyGroup, "--key", key}
+	if value == "" {
+		args = append(args, "--delete")
+	} else {
+		args = append(args, value)
+	}
+	_, err := b.run("kwriteconfig"+b.version, args...)
+	return err
+}
+
+// notify asks running KIO workers to reread the proxy configuration. It is
+// best effort: the settings are already written.
+func (b kdeBackend) notify() {
+	_, _ = b.run("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:")
+}
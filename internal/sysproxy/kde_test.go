@@ -0,0 +1,76 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeKConfig stands in for kreadconfig/kwriteconfig and dbus-send.
+type fakeKConfig struct {
+	values   map[string]string
+	notified int
+}
+
+func (f *fakeKConfig) run(name string, args ...string) (string, error) {
+	if name == "dbus-send" {
+		f.notified++
+		return "", nil
+	}
+	if len(args) < 6 || args[1] != "kioslaverc" || args[3] != "Proxy Settings" {
+		return "", fmt.Errorf("unexpected command %s %v", name, args)
+	}
+	key := args[5]
+	switch name {
+	case "kreadconfig6":
+		return f.values[key], nil
+	case "kwriteconfig6":
+		if args[6] == "--delete" {
+			delete(f.values, key)
+		} else {
+			f.values[key] = args[6]
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected command %s", name)
+}
+
+func TestKDEBackendApplyAndRestore(t *testing.T) {
+	fake := &fakeKConfig{values: map[string]string{"ProxyType": "0", "NoProxyFor": "corp.example", "socksProxy": "socks://10.0.0.1 1080"}}
+	b := kdeBackend{run: fake.run, version: "6"}
+
+	snap, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if b.Configured(snap) {
+		t.Fatal("ProxyType 0 should not count as configured")
+	}
+	if err := b.Apply(snap, &InboundEndpoint{Protocol: "http", Host: "127.0.0.1", Port: 10809}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if fake.values["ProxyType"] != "1" || fake.values["httpProxy"] != "http://127.0.0.1 10809" {
+		t.Fatalf("unexpected applied settings: %#v", fake.values)
+	}
+	if _, stale := fake.values["socksProxy"]; stale {
+		t.Fatalf("stale socksProxy should be cleared: %#v", fake.values)
+	}
+	if !strings.HasPrefix(fake.values["NoProxyFor"], "corp.example,localhost,127.0.0.0/8") {
+		t.Fatalf("unexpected NoProxyFor: %s", fake.values["NoProxyFor"])
+	}
+
+	if err := b.Restore(snap); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if len(fake.values) != 3 || fake.values["ProxyType"] != "0" || fake.values["NoProxyFor"] != "corp.example" ||
+		fake.values["socksProxy"] != "socks://10.0.0.1 1080" {
+		t.Fatalf("settings not restored: %#v", fake.values)
+	}
+	if fake.notified != 2 {
+		t.Fatalf("expected KIO to be notified twice, got %d", fake.notified)
+	}
+
+	if !b.Configured(map[string]string{"ProxyType": "2"}) {
+		t.Fatal("PAC ProxyType should count as configured")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package sysproxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ProcessAlive reports whether pid is a running process.
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
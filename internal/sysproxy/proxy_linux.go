@@ -0,0 +1,43 @@
+//go:build linux
+
+package sysproxy
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// detectPlatformBackend picks a backend from XDG_CURRENT_DESKTOP. Headless
+// sessions have no desktop and keep the system proxy untouched.
+func detectPlatformBackend() Backend {
+	desktop := strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP"))
+	switch {
+	case desktop == "":
+		return nil
+	case strings.Contains(desktop, "KDE"):
+		return platformBackend(kdeBackendName)
+	case strings.Contains(desktop, "GNOME"), strings.Contains(desktop, "UNITY"),
+		strings.Contains(desktop, "CINNAMON"), strings.Contains(desktop, "BUDGIE"),
+		strings.Contains(desktop, "PANTHEON"):
+		return platformBackend(gnomeBackendName)
+	default:
+		return nil
+	}
+}
+
+func platformBackend(name string) Backend {
+	switch name {
+	case gnomeBackendName:
+		if _, err := exec.LookPath("gsettings"); err == nil {
+			return gnomeBackend{run: execCommand}
+		}
+	case kdeBackendName:
+		for _, version := range []string{"6", "5"} {
+			if _, err := exec.LookPath("kwriteconfig" + version); err == nil {
+				return kdeBackend{run: execCommand, version: version}
+			}
+		}
+	}
+	return nil
+}
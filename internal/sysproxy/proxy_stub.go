@@ -1,7 +1,11 @@
-//go:build !windows
+//go:build !windows && !linux
 
 package sysproxy
 
-func ConfigureForRun(_ string) (func() error, bool, error) {
-	return func() error { return nil }, false, nil
+func detectPlatformBackend() Backend {
+	return nil
+}
+
+func platformBackend(string) Backend {
+	return nil
 }
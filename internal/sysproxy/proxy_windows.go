@@ -4,6 +4,7 @@ package sysproxy
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -22,6 +23,8 @@ var (
 	procInternetSetOptionW = wininet.NewProc("InternetSetOptionW")
 )
 
+const windowsBackendName = "windows"
+
 type proxySnapshot struct {
 	ProxyEnable   uint32
 	ProxyServer   string
@@ -29,31 +32,51 @@ type proxySnapshot struct {
 	AutoConfigURL string
 }
 
-func ConfigureForRun(xrayConfigPath string) (func() error, bool, error) {
-	snapshot, err := readProxySnapshot()
-	if err != nil {
-		return nil, false, err
-	}
+// windowsBackend manages the WinINet settings under HKCU.
+type windowsBackend struct{}
 
-	if snapshot.ProxyEnable != 0 || strings.TrimSpace(snapshot.AutoConfigURL) != "" {
-		return func() error { return nil }, false, nil
+func detectPlatformBackend() Backend {
+	return windowsBackend{}
+}
+
+func platformBackend(name string) Backend {
+	if name == windowsBackendName {
+		return windowsBackend{}
 	}
+	return nil
+}
+
+func (windowsBackend) Name() string { return windowsBackendName }
 
-	endpoint, err := DetectProxyEndpoint(xrayConfigPath)
+func (windowsBackend) Snapshot() (map[string]string, error) {
+	snapshot, err := readProxySnapshot()
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
+	return map[string]string{
+		"ProxyEnable":   strconv.FormatUint(uint64(snapshot.ProxyEnable), 10),
+		"ProxyServer":   snapshot.ProxyServer,
+		"ProxyOverride": snapshot.ProxyOverride,
+		"AutoConfigURL": snapshot.AutoConfigURL,
+	}, nil
+}
 
-	proxyServer := fmt.Sprintf("%s:%d", endpoint.Host, endpoint.Port)
-	bypass := mergeBypass(snapshot.ProxyOverride, RequiredBypassList)
-	if err := applyProxySettings(1, proxyServer, bypass, ""); err != nil {
-		return nil, false, err
-	}
+func (windowsBackend) Configured(snap map[string]string) bool {
+	return snap["ProxyEnable"] != "0" || strings.TrimSpace(snap["AutoConfigURL"]) != ""
+}
+
+func (windowsBackend) Apply(snap map[string]string, ep *InboundEndpoint) error {
+	proxyServer := fmt.Sprintf("%s:%d", ep.Host, ep.Port)
+	bypass := mergeBypass(snap["ProxyOverride"], RequiredBypassList)
+	return applyProxySettings(1, proxyServer, bypass, "")
+}
 
-	restore := func() error {
-		return applyProxySettings(snapshot.ProxyEnable, snapshot.ProxyServer, snapshot.ProxyOverride, snapshot.AutoConfigURL)
+func (windowsBackend) Restore(snap map[string]string) error {
+	enable, err := strconv.ParseUint(snap["ProxyEnable"], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid ProxyEnable in snapshot: %w", err)
 	}
-	return restore, true, nil
+	return applyProxySettings(uint32(enable), snap["ProxyServer"], snap["ProxyOverride"], snap["AutoConfigURL"])
 }
 
 func readProxySnapshot() (*proxySnapshot, error) {
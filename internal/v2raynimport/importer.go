@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/lkimju1/v2n-coremesh/internal/config"
+	"gopkg.in/yaml.v3"
 
 	_ "modernc.org/sqlite"
 )
@@ -33,6 +34,7 @@ type profileRow struct {
 	CoreType   sql.NullInt64
 	Remarks    sql.NullString
 	Address    sql.NullString
+	fields     map[string]string
 }
 
 type routingRow struct {
@@ -40,11 +42,22 @@ type routingRow struct {
 }
 
 type ruleItem struct {
+	Remarks     string   `json:"Remarks"`
+	RuleType    *int     `json:"RuleType"`
 	OutboundTag string   `json:"OutboundTag"`
 	Domain      []string `json:"Domain"`
+	IP          []string `json:"Ip"`
+	Port        string   `json:"Port"`
+	Network     string   `json:"Network"`
+	Protocol    []string `json:"Protocol"`
+	InboundTag  []string `json:"InboundTag"`
+	Process     []string `json:"Process"`
 	Enabled     bool     `json:"Enabled"`
 }
 
+// ruleTypeDNS marks v2rayN rules that only apply to DNS, not routing.
+const ruleTypeDNS = 2
+
 func LoadFromHome(home string) (*config.File, *config.Routing, error) {
 	home = filepath.Clean(home)
 	guiConfigPath := filepath.Join(home, "guiConfigs", "guiNConfig.json")
@@ -87,7 +100,8 @@ func LoadFromHome(home string) (*config.File, *config.Routing, error) {
 	customProfiles := filterCustomProfiles(profiles)
 	cores := make([]config.Core, 0, len(customProfiles))
 	remarkToTag := make(map[string]string)
-	aliasUseCount := make(map[string]int)
+	// "xray" names the xray process in the control API.
+	aliasUseCount := map[string]int{"xray": 1}
 	for _, p := range customProfiles {
 		coreType := int64(coreTypeXray)
 		if p.CoreType.Valid {
@@ -133,8 +147,39 @@ func LoadFromHome(home string) (*config.File, *config.Routing, error) {
 		}
 	}
 
-	if len(cores) == 0 {
-		return nil, nil, fmt.Errorf("no custom core profiles found in v2rayN db")
+	outbounds := make([]config.Outbound, 0)
+	var skipped []config.SkippedProfile
+	for _, p := range filterStandardProfiles(profiles) {
+		name := strings.TrimSpace(p.Remarks.String)
+		if name == "" {
+			name = p.IndexID
+		}
+		ob, err := buildOutbound(p)
+		if err != nil {
+			skipped = append(skipped, config.SkippedProfile{ProfileID: p.IndexID, Name: name, Reason: err.Error()})
+			continue
+		}
+		alias := uniqueAlias(aliasUseCount, sanitizeTag(name))
+		outbounds = append(outbounds, config.Outbound{
+			ProfileID: p.IndexID,
+			Name:      name,
+			Tag:       alias,
+			Active:    guiCfg != nil && strings.EqualFold(strings.TrimSpace(guiCfg.IndexID), strings.TrimSpace(p.IndexID)),
+			Xray:      ob,
+		})
+		remark := strings.TrimSpace(p.Remarks.String)
+		if remark != "" {
+			if _, ok := remarkToTag[remark]; !ok {
+				remarkToTag[remark] = alias
+			}
+		}
+	}
+
+	if len(cores) == 0 && len(outbounds) == 0 {
+		if len(skipped) > 0 {
+			return nil, nil, fmt.Errorf("no usable profiles in v2rayN db: %s(%s): %s", skipped[0].ProfileID, skipped[0].Name, skipped[0].Reason)
+		}
+		return nil, nil, fmt.Errorf("no custom core or standard profiles found in v2rayN db")
 	}
 
 	routing, err := readRouting(db, remarkToTag)
@@ -152,7 +197,9 @@ func LoadFromHome(home string) (*config.File, *config.Routing, error) {
 			BaseConfig: xrayBase,
 			Args:       []string{"run", "-c", "{{config}}"},
 		},
-		Cores: cores,
+		Cores:     cores,
+		Outbounds: outbounds,
+		Skipped:   skipped,
 	}
 	return mainCfg, routing, nil
 }
@@ -170,22 +217,72 @@ func readGuiConfig(path string) (*guiConfig, error) {
 }
 
 func readProfiles(db *sql.DB) ([]profileRow, error) {
-	rows, err := db.Query(`SELECT IndexId, ConfigType, CoreType, Remarks, Address FROM ProfileItem`)
+	// Column sets differ between v2rayN versions, so read whatever exists.
+	rows, err := db.Query(`SELECT * FROM ProfileItem`)
 	if err != nil {
 		return nil, fmt.Errorf("query ProfileItem: %w", err)
 	}
 	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("read ProfileItem columns: %w", err)
+	}
 	out := make([]profileRow, 0)
 	for rows.Next() {
-		var r profileRow
-		if err := rows.Scan(&r.IndexID, &r.ConfigType, &r.CoreType, &r.Remarks, &r.Address); err != nil {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
 			return nil, fmt.Errorf("scan ProfileItem: %w", err)
 		}
-		out = append(out, r)
+		fields := make(map[string]string, len(cols))
+		for i, c := range cols {
+			if vals[i] != nil {
+				fields[c] = sqlValueString(vals[i])
+			}
+		}
+		out = append(out, newProfileRow(fields))
 	}
 	return out, rows.Err()
 }
 
+func newProfileRow(fields map[string]string) profileRow {
+	r := profileRow{IndexID: fields["IndexId"], fields: fields}
+	r.ConfigType, _ = strconv.Atoi(strings.TrimSpace(fields["ConfigType"]))
+	if v, ok := fields["CoreType"]; ok {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			r.CoreType = sql.NullInt64{Int64: n, Valid: true}
+		}
+	}
+	if v, ok := fields["Remarks"]; ok {
+		r.Remarks = sql.NullString{String: v, Valid: true}
+	}
+	if v, ok := fields["Address"]; ok {
+		r.Address = sql.NullString{String: v, Valid: true}
+	}
+	return r
+}
+
+func sqlValueString(v any) string {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// field returns a trimmed ProfileItem column, or "" when the column is absent.
+func (r profileRow) field(name string) string {
+	return strings.TrimSpace(r.fields[name])
+}
+
 func filterCustomProfiles(profiles []profileRow) []profileRow {
 	out := make([]profileRow, 0)
 	for _, p := range profiles {
@@ -197,6 +294,17 @@ func filterCustomProfiles(profiles []profileRow) []profileRow {
 	return out
 }
 
+func filterStandardProfiles(profiles []profileRow) []profileRow {
+	out := make([]profileRow, 0)
+	for _, p := range profiles {
+		if isStandardProfile(p.ConfigType) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IndexID < out[j].IndexID })
+	return out
+}
+
 func detectXrayBaseConfig(home string) (string, error) {
 	// configPre.json is generated for pre-service xray/sing-box. create_exe 2.0 requires this file explicitly.
 	runtimePreConfig := filepath.Join(home, "binConfigs", "configPre.json")
@@ -296,7 +404,15 @@ func inferListenFromConfig(path string) (string, int, error) {
 	}
 	var doc any
 	if err := json.Unmarshal(b, &doc); err != nil {
-		return "", 0, fmt.Errorf("parse json core config: %w", err)
+		// mihomo/clash profiles are YAML.
+		var yamlDoc map[string]any
+		if yamlErr := yaml.Unmarshal(b, &yamlDoc); yamlErr != nil || yamlDoc == nil {
+			return "", 0, fmt.Errorf("parse core config as json or yaml: %w", err)
+		}
+		if h, p, ok := parseMihomoListen(yamlDoc); ok {
+			return h, p, nil
+		}
+		return "", 0, fmt.Errorf("cannot infer listen host:port from %s", path)
 	}
 
 	if m, ok := doc.(map[string]any); ok {
@@ -402,16 +518,67 @@ func parseListenArray(listenArr []any) (string, int, bool) {
 	return "", 0, false
 }
 
+// parseMihomoListen reads the top-level socks-port/mixed-port (bound to
+// bind-address) or the first socks/mixed entry under listeners.
+func parseMihomoListen(doc map[string]any) (string, int, bool) {
+	host := "127.0.0.1"
+	if v, ok := doc["bind-address"].(string); ok {
+		host = normalizeHost(v)
+	}
+	for _, key := range []string{"socks-port", "mixed-port"} {
+		if p := yamlPort(doc[key]); p > 0 {
+			return host, p, true
+		}
+	}
+	listeners, _ := doc["listeners"].([]any)
+	for _, want := range []string{"socks", "mixed"} {
+		for _, raw := range listeners {
+			m, ok := raw.(map[string]any)
+			if !ok {
+				continue
+			}
+			typ, _ := m["type"].(string)
+			if !strings.EqualFold(strings.TrimSpace(typ), want) {
+				continue
+			}
+			p := yamlPort(m["port"])
+			if p <= 0 {
+				continue
+			}
+			listen, _ := m["listen"].(string)
+			return normalizeHost(listen), p, true
+		}
+	}
+	return "", 0, false
+}
+
+func yamlPort(v any) int {
+	switch p := v.(type) {
+	case int:
+		return p
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(p))
+		return n
+	default:
+		return 0
+	}
+}
+
 func normalizeHost(host string) string {
 	h := strings.TrimSpace(strings.Trim(host, "[]"))
 	switch h {
-	case "", "0.0.0.0", "::":
+	case "", "*", "0.0.0.0", "::":
 		return "127.0.0.1"
 	default:
 		return h
 	}
 }
 
+// groupTagPrefix marks a v2rayN rule outbound that names a core group.
+const groupTagPrefix = "group:"
+
+// readRouting translates the active routing set. No default outbound is set:
+// like v2rayN, unmatched traffic goes to the first outbound of configPre.json.
 func readRouting(db *sql.DB, remarkToTag map[string]string) (*config.Routing, error) {
 	rows, err := db.Query(`SELECT RuleSet FROM RoutingItem WHERE IsActive = 1 LIMIT 1`)
 	if err != nil {
@@ -420,40 +587,110 @@ func readRouting(db *sql.DB, remarkToTag map[string]string) (*config.Routing, er
 	defer rows.Close()
 	var rr routingRow
 	if !rows.Next() {
-		return &config.Routing{DefaultOutboundTag: "direct"}, nil
+		return &config.Routing{}, nil
 	}
 	if err := rows.Scan(&rr.RuleSet); err != nil {
 		return nil, fmt.Errorf("scan active routing: %w", err)
 	}
 	if !rr.RuleSet.Valid || strings.TrimSpace(rr.RuleSet.String) == "" {
-		return &config.Routing{DefaultOutboundTag: "direct"}, nil
+		return &config.Routing{}, nil
 	}
 	var items []ruleItem
 	if err := json.Unmarshal([]byte(rr.RuleSet.String), &items); err != nil {
 		return nil, fmt.Errorf("parse routing ruleset: %w", err)
 	}
-	out := &config.Routing{Rules: make([]config.RoutingRule, 0), DefaultOutboundTag: "direct"}
-	for _, it := range items {
-		if !it.Enabled || len(it.Domain) == 0 {
+	out := &config.Routing{Rules: make([]config.RoutingRule, 0)}
+	for i, it := range items {
+		if !it.Enabled {
 			continue
 		}
-		tag := strings.TrimSpace(it.OutboundTag)
-		if tag == "" || tag == "proxy" || tag == "block" {
+		if it.RuleType != nil && *it.RuleType == ruleTypeDNS {
 			continue
 		}
-		if tag == "direct" {
-			out.Rules = append(out.Rules, config.RoutingRule{Name: "direct", Domain: it.Domain, OutboundTag: "direct"})
+		tag := strings.TrimSpace(it.OutboundTag)
+		name := strings.TrimSpace(it.Remarks)
+		if name == "" {
+			name = tag
+		}
+		if tag == "" {
+			out.Skipped = append(out.Skipped, config.SkippedRule{Index: i, Name: name, Reason: "empty outbound tag"})
 			continue
 		}
-		mapped, ok := remarkToTag[tag]
-		if !ok {
+		mapped := tag
+		group := ""
+		switch {
+		case tag == "direct" || tag == "proxy" || tag == "block":
+		case strings.HasPrefix(tag, groupTagPrefix):
+			// A "group:<name>" outbound targets a core group from groups.yaml.
+			group = strings.TrimSpace(strings.TrimPrefix(tag, groupTagPrefix))
+			mapped = ""
+		default:
+			var ok bool
+			mapped, ok = remarkToTag[tag]
+			if !ok {
+				out.Skipped = append(out.Skipped, config.SkippedRule{Index: i, Name: name, Reason: fmt.Sprintf("outbound %q is not an imported profile", tag)})
+				continue
+			}
+		}
+		rules := splitRuleItem(it, name, mapped)
+		if len(rules) == 0 {
+			out.Skipped = append(out.Skipped, config.SkippedRule{Index: i, Name: name, Reason: "rule has no domain, ip, port, network, protocol, inbound or process matcher"})
 			continue
 		}
-		out.Rules = append(out.Rules, config.RoutingRule{Name: tag, Domain: it.Domain, OutboundTag: mapped})
+		for j := range rules {
+			rules[j].Group = group
+		}
+		out.Rules = append(out.Rules, rules...)
 	}
 	return out, nil
 }
 
+// splitRuleItem converts a v2rayN rule into xray rules. v2rayN treats the
+// domain and ip lists as alternatives, while xray requires every field of a
+// rule to match, so a rule carrying both becomes two rules.
+func splitRuleItem(it ruleItem, name, outboundTag string) []config.RoutingRule {
+	base := config.RoutingRule{
+		Name:        name,
+		Port:        strings.TrimSpace(it.Port),
+		Network:     strings.TrimSpace(it.Network),
+		Protocol:    nonEmpty(it.Protocol),
+		InboundTag:  nonEmpty(it.InboundTag),
+		Process:     nonEmpty(it.Process),
+		OutboundTag: outboundTag,
+	}
+	domain := nonEmpty(it.Domain)
+	ip := nonEmpty(it.IP)
+	out := make([]config.RoutingRule, 0, 2)
+	if len(domain) > 0 {
+		r := base
+		r.Domain = domain
+		out = append(out, r)
+	}
+	if len(ip) > 0 {
+		r := base
+		r.IP = ip
+		out = append(out, r)
+	}
+	if len(out) == 0 && (base.Port != "" || base.Network != "" || len(base.Protocol) > 0 || len(base.InboundTag) > 0 || len(base.Process) > 0) {
+		out = append(out, base)
+	}
+	return out
+}
+
+func nonEmpty(v []string) []string {
+	out := make([]string, 0, len(v))
+	for _, item := range v {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
 func sanitizeTag(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	s = strings.ReplaceAll(s, "_", "-")
@@ -1,6 +1,7 @@
 package v2raynimport
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"testing"
@@ -85,3 +86,202 @@ func TestDetectXrayBaseConfigErrorWhenConfigPreMissing(t *testing.T) {
 		t.Fatal("expected error when configPre.json is missing")
 	}
 }
+
+func TestInferListenFromMihomoYAML(t *testing.T) {
+	tmp := t.TempDir()
+	cases := []struct {
+		name    string
+		content string
+		host    string
+		port    int
+	}{
+		{
+			name:    "mixed-port",
+			content: "mixed-port: 7890\nallow-lan: false\nbind-address: '*'\nproxies: []\n",
+			host:    "127.0.0.1",
+			port:    7890,
+		},
+		{
+			name:    "socks-port-preferred",
+			content: "port: 7890\nsocks-port: 7891\nmixed-port: 7892\nbind-address: 127.0.0.2\n",
+			host:    "127.0.0.2",
+			port:    7891,
+		},
+		{
+			name: "listeners",
+			content: `listeners:
+  - name: http-in
+    type: http
+    port: 7990
+  - name: socks-in
+    type: socks
+    port: 7991
+    listen: 127.0.0.1
+`,
+			host: "127.0.0.1",
+			port: 7991,
+		},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(tmp, tc.name+".yaml")
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		host, port, err := inferListenFromConfig(path)
+		if err != nil {
+			t.Fatalf("%s: infer listen: %v", tc.name, err)
+		}
+		if host != tc.host || port != tc.port {
+			t.Fatalf("%s: got %s:%d, want %s:%d", tc.name, host, port, tc.host, tc.port)
+		}
+	}
+
+	noPorts := filepath.Join(tmp, "no-ports.yaml")
+	if err := os.WriteFile(noPorts, []byte("port: 7890\nproxies: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := inferListenFromConfig(noPorts); err == nil {
+		t.Fatal("expected error for profile without socks/mixed inbound")
+	}
+}
+
+func TestLoadFromHomeImportsStandardProfiles(t *testing.T) {
+	home := newTestHome(t, []map[string]any{
+		{"IndexId": "a1", "ConfigType": 2, "CoreType": 22, "Remarks": "naive A", "Address": "naive-a.json"},
+		{"IndexId": "b1", "ConfigType": 5, "CoreType": nil, "Remarks": "vless B", "Address": "vless.example.com", "Port": 443, "Id": "uuid-b", "Network": "tcp"},
+		{"IndexId": "c1", "ConfigType": 8, "CoreType": nil, "Remarks": "tuic C", "Address": "tuic.example.com", "Port": 443},
+		{"IndexId": "d1", "ConfigType": 5, "CoreType": nil, "Remarks": "broken D", "Address": "", "Port": 443, "Id": "uuid-d", "Network": "tcp"},
+	}, `[
+  {"OutboundTag":"vless B","Domain":["domain:b.example.com"],"Enabled":true},
+  {"OutboundTag":"naive A","Domain":["domain:a.example.com"],"Enabled":true}
+]`)
+	if err := os.WriteFile(filepath.Join(home, "guiConfigs", "naive-a.json"), []byte(`{"listen":"socks://127.0.0.1:1080"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, routing, err := LoadFromHome(home)
+	if err != nil {
+		t.Fatalf("load from home: %v", err)
+	}
+	if len(cfg.Cores) != 1 || cfg.Cores[0].Alias != "naive-a" {
+		t.Fatalf("unexpected cores: %#v", cfg.Cores)
+	}
+	if len(cfg.Outbounds) != 1 {
+		t.Fatalf("expected only the vless profile as outbound: %#v", cfg.Outbounds)
+	}
+	ob := cfg.Outbounds[0]
+	if ob.Tag != "vless-b" || ob.Xray["protocol"] != "vless" {
+		t.Fatalf("unexpected outbound: %#v", ob)
+	}
+	if len(cfg.Skipped) != 1 || cfg.Skipped[0].ProfileID != "d1" || cfg.Skipped[0].Reason != "address is empty" {
+		t.Fatalf("expected the broken profile to be skipped: %#v", cfg.Skipped)
+	}
+	if len(routing.Rules) != 2 || routing.Rules[0].OutboundTag != "vless-b" || routing.Rules[1].OutboundTag != "naive-a" {
+		t.Fatalf("unexpected routing rules: %#v", routing.Rules)
+	}
+}
+
+// newTestHome creates a minimal v2rayN home with the given ProfileItem rows
+// and an active RoutingItem ruleset.
+func newTestHome(t *testing.T, profiles []map[string]any, ruleSet string) string {
+	t.Helper()
+	home := t.TempDir()
+	for _, dir := range []string{"guiConfigs", "binConfigs", filepath.Join("bin", "xray"), filepath.Join("bin", "naiveproxy")} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join("guiConfigs", "guiNConfig.json"): `{"IndexId":""}`,
+		filepath.Join("binConfigs", "configPre.json"): `{
+  "inbounds":[{"protocol":"mixed","listen":"127.0.0.1","port":10808}],
+  "outbounds":[{"tag":"proxy","protocol":"socks"}],
+  "routing":{"rules":[{"type":"field","outboundTag":"proxy"}]}
+}`,
+		filepath.Join("bin", "xray", "xray"):        "",
+		filepath.Join("bin", "naiveproxy", "naive"): "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(home, "guiConfigs", "guiNDB.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE ProfileItem (IndexId TEXT, ConfigType INTEGER, CoreType INTEGER, Remarks TEXT, Address TEXT,
+			Port INTEGER, Id TEXT, AlterId INTEGER, Security TEXT, Network TEXT, StreamSecurity TEXT)`,
+		`CREATE TABLE RoutingItem (RuleSet TEXT, IsActive INTEGER)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range profiles {
+		if _, err := db.Exec(`INSERT INTO ProfileItem (IndexId, ConfigType, CoreType, Remarks, Address, Port, Id, Network) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			p["IndexId"], p["ConfigType"], p["CoreType"], p["Remarks"], p["Address"], p["Port"], p["Id"], p["Network"]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO RoutingItem (RuleSet, IsActive) VALUES (?, 1)`, ruleSet); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+func TestLoadFromHomeTranslatesRoutingRules(t *testing.T) {
+	home := newTestHome(t, []map[string]any{
+		{"IndexId": "a1", "ConfigType": 2, "CoreType": 22, "Remarks": "naive A", "Address": "naive-a.json"},
+	}, `[
+  {"Remarks":"private","OutboundTag":"direct","Ip":["geoip:private"],"Enabled":true},
+  {"OutboundTag":"proxy","Port":"443","Network":"tcp","Enabled":true},
+  {"OutboundTag":"block","Domain":["geosite:category-ads-all"],"Enabled":true},
+  {"OutboundTag":"naive A","Domain":["domain:a.example.com"],"Ip":["1.1.1.1/32"],"Protocol":["tls"],"Enabled":true},
+  {"OutboundTag":"missing profile","Domain":["domain:m.example.com"],"Enabled":true},
+  {"OutboundTag":"group:us-exits","Domain":["domain:us.example.com"],"Enabled":true},
+  {"OutboundTag":"direct","Enabled":true},
+  {"OutboundTag":"direct","Domain":["domain:dns-only.example.com"],"RuleType":2,"Enabled":true},
+  {"OutboundTag":"direct","Domain":["domain:disabled.example.com"],"Enabled":false}
+]`)
+	if err := os.WriteFile(filepath.Join(home, "guiConfigs", "naive-a.json"), []byte(`{"listen":"socks://127.0.0.1:1080"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, routing, err := LoadFromHome(home)
+	if err != nil {
+		t.Fatalf("load from home: %v", err)
+	}
+	if len(routing.Rules) != 6 {
+		t.Fatalf("unexpected rules: %#v", routing.Rules)
+	}
+	if r := routing.Rules[0]; r.Name != "private" || r.OutboundTag != "direct" || len(r.IP) != 1 || len(r.Domain) != 0 {
+		t.Fatalf("unexpected ip rule: %#v", r)
+	}
+	if r := routing.Rules[1]; r.OutboundTag != "proxy" || r.Port != "443" || r.Network != "tcp" {
+		t.Fatalf("unexpected port rule: %#v", r)
+	}
+	if r := routing.Rules[2]; r.OutboundTag != "block" || r.Domain[0] != "geosite:category-ads-all" {
+		t.Fatalf("unexpected block rule: %#v", r)
+	}
+	domainRule, ipRule := routing.Rules[3], routing.Rules[4]
+	if domainRule.OutboundTag != "naive-a" || len(domainRule.Domain) != 1 || len(domainRule.IP) != 0 || domainRule.Protocol[0] != "tls" {
+		t.Fatalf("unexpected split domain rule: %#v", domainRule)
+	}
+	if ipRule.OutboundTag != "naive-a" || len(ipRule.IP) != 1 || len(ipRule.Domain) != 0 || ipRule.Protocol[0] != "tls" {
+		t.Fatalf("unexpected split ip rule: %#v", ipRule)
+	}
+	if r := routing.Rules[5]; r.Group != "us-exits" || r.OutboundTag != "" || r.Domain[0] != "domain:us.example.com" {
+		t.Fatalf("unexpected group rule: %#v", r)
+	}
+	if len(routing.Skipped) != 2 {
+		t.Fatalf("expected two skipped rules: %#v", routing.Skipped)
+	}
+	if routing.Skipped[0].Index != 4 || routing.Skipped[1].Index != 6 {
+		t.Fatalf("unexpected skipped rules: %#v", routing.Skipped)
+	}
+}
@@ -0,0 +1,230 @@
+package v2raynimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// v2rayN EConfigType values of the profiles that translate to xray outbounds.
+const (
+	configTypeVMess       = 1
+	configTypeShadowsocks = 3
+	configTypeVLESS       = 5
+	configTypeTrojan      = 6
+	configTypeHysteria2   = 7
+)
+
+func isStandardProfile(configType int) bool {
+	switch configType {
+	case configTypeVMess, configTypeShadowsocks, configTypeVLESS, configTypeTrojan, configTypeHysteria2:
+		return true
+	default:
+		return false
+	}
+}
+
+// buildOutbound translates a standard v2rayN profile row into an xray
+// outbound object without tag.
+func buildOutbound(p profileRow) (map[string]any, error) {
+	address := p.field("Address")
+	if address == "" {
+		return nil, fmt.Errorf("address is empty")
+	}
+	port, err := strconv.Atoi(p.field("Port"))
+	if err != nil || port <= 0 {
+		return nil, fmt.Errorf("invalid port %q", p.field("Port"))
+	}
+	id := p.field("Id")
+
+	var out map[string]any
+	switch p.ConfigType {
+	case configTypeVMess:
+		alterID, _ := strconv.Atoi(p.field("AlterId"))
+		out = map[string]any{
+			"protocol": "vmess",
+			"settings": map[string]any{"vnext": []any{map[string]any{
+				"address": address,
+				"port":    port,
+				"users": []any{map[string]any{
+					"id":       id,
+					"alterId":  alterID,
+					"security": valueOr(p.field("Security"), "auto"),
+				}},
+			}}},
+		}
+	case configTypeVLESS:
+		user := map[string]any{
+			"id":         id,
+			"encryption": valueOr(p.field("Security"), "none"),
+		}
+		if flow := p.field("Flow"); flow != "" {
+			user["flow"] = flow
+		}
+		out = map[string]any{
+			"protocol": "vless",
+			"settings": map[string]any{"vnext": []any{map[string]any{
+				"address": address,
+				"port":    port,
+				"users":   []any{user},
+			}}},
+		}
+	case configTypeTrojan:
+		server := map[string]any{"address": address, "port": port, "password": id}
+		if flow := p.field("Flow"); flow != "" {
+			server["flow"] = flow
+		}
+		out = map[string]any{
+			"protocol": "trojan",
+			"settings": map[string]any{"servers": []any{server}},
+		}
+	case configTypeShadowsocks:
+		if p.field("Security") == "" {
+			return nil, fmt.Errorf("shadowsocks method is empty")
+		}
+		out = map[string]any{
+			"protocol": "shadowsocks",
+			"settings": map[string]any{"servers": []any{map[string]any{
+				"address":  address,
+				"port":     port,
+				"method":   p.field("Security"),
+				"password": id,
+			}}},
+		}
+	case configTypeHysteria2:
+		// Requires an xray build with the hysteria outbound (version 2).
+		out = map[string]any{
+			"protocol": "hysteria",
+			"settings": map[string]any{
+				"version": 2,
+				"address": address,
+				"port":    port,
+			},
+			"streamSettings": map[string]any{
+				"network":          "hysteria",
+				"hysteriaSettings": map[string]any{"version": 2, "auth": id},
+				"security":         "tls",
+				"tlsSettings":      tlsSettings(p),
+			},
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported config type %d", p.ConfigType)
+	}
+	out["streamSettings"] = streamSettings(p)
+	return out, nil
+}
+
+func streamSettings(p profileRow) map[string]any {
+	network := strings.ToLower(valueOr(p.field("Network"), "tcp"))
+	host := p.field("RequestHost")
+	path := p.field("Path")
+	headerType := p.field("HeaderType")
+	ss := map[string]any{"network": network}
+
+	switch network {
+	case "tcp", "raw":
+		if headerType == "http" {
+			request := map[string]any{"path": splitList(valueOr(path, "/"))}
+			if host != "" {
+				request["headers"] = map[string]any{"Host": splitList(host)}
+			}
+			ss["tcpSettings"] = map[string]any{"header": map[string]any{"type": "http", "request": request}}
+		}
+	case "ws":
+		ss["wsSettings"] = withNonEmpty(map[string]any{}, "path", path, "host", host)
+	case "httpupgrade":
+		ss["httpupgradeSettings"] = withNonEmpty(map[string]any{}, "path", path, "host", host)
+	case "xhttp", "splithttp":
+		ss["network"] = "xhttp"
+		ss["xhttpSettings"] = withNonEmpty(map[string]any{}, "path", path, "host", host, "mode", headerType)
+	case "h2", "http":
+		settings := withNonEmpty(map[string]any{}, "path", path)
+		if host != "" {
+			settings["host"] = splitList(host)
+		}
+		ss["httpSettings"] = settings
+	case "grpc":
+		settings := withNonEmpty(map[string]any{}, "serviceName", path, "authority", host)
+		if headerType == "multi" {
+			settings["multiMode"] = true
+		}
+		ss["grpcSettings"] = settings
+	case "kcp":
+		settings := map[string]any{"header": map[string]any{"type": valueOr(headerType, "none")}}
+		if path != "" {
+			settings["seed"] = path
+		}
+		ss["kcpSettings"] = settings
+	}
+
+	switch strings.ToLower(p.field("StreamSecurity")) {
+	case "tls":
+		ss["security"] = "tls"
+		ss["tlsSettings"] = tlsSettings(p)
+	case "reality":
+		ss["security"] = "reality"
+		ss["realitySettings"] = withNonEmpty(map[string]any{},
+			"serverName", p.field("Sni"),
+			"fingerprint", p.field("Fingerprint"),
+			"publicKey", p.field("PublicKey"),
+			"shortId", p.field("ShortId"),
+			"spiderX", p.field("SpiderX"),
+		)
+	}
+	return ss
+}
+
+func tlsSettings(p profileRow) map[string]any {
+	serverName := p.field("Sni")
+	if serverName == "" {
+		serverName = firstOf(splitList(p.field("RequestHost")))
+	}
+	settings := withNonEmpty(map[string]any{},
+		"serverName", serverName,
+		"fingerprint", p.field("Fingerprint"),
+	)
+	if alpn := splitList(p.field("Alpn")); len(alpn) > 0 {
+		settings["alpn"] = alpn
+	}
+	switch strings.ToLower(p.field("AllowInsecure")) {
+	case "true", "1":
+		settings["allowInsecure"] = true
+	}
+	return settings
+}
+
+// withNonEmpty sets key/value pairs on m, skipping empty values.
+func withNonEmpty(m map[string]any, kv ...string) map[string]any {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			m[kv[i]] = kv[i+1]
+		}
+	}
+	return m
+}
+
+func splitList(v string) []string {
+	out := make([]string, 0)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func firstOf(v []string) string {
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+func valueOr(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,106 @@
+package v2raynimport
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildOutboundVLESSReality(t *testing.T) {
+	p := newProfileRow(map[string]string{
+		"IndexId": "1", "ConfigType": "5", "Address": "vless.example.com", "Port": "443",
+		"Id": "uuid-1", "Security": "", "Network": "tcp", "Flow": "xtls-rprx-vision",
+		"StreamSecurity": "reality", "Sni": "www.example.com", "Fingerprint": "chrome",
+		"PublicKey": "pubkey", "ShortId": "abcd",
+	})
+	ob, err := buildOutbound(p)
+	if err != nil {
+		t.Fatalf("build outbound: %v", err)
+	}
+	assertJSON(t, ob, `{
+  "protocol": "vless",
+  "settings": {"vnext": [{"address": "vless.example.com", "port": 443,
+    "users": [{"id": "uuid-1", "encryption": "none", "flow": "xtls-rprx-vision"}]}]},
+  "streamSettings": {"network": "tcp", "security": "reality",
+    "realitySettings": {"serverName": "www.example.com", "fingerprint": "chrome", "publicKey": "pubkey", "shortId": "abcd"}}
+}`)
+}
+
+func TestBuildOutboundVMessWebSocketTLS(t *testing.T) {
+	p := newProfileRow(map[string]string{
+		"IndexId": "2", "ConfigType": "1", "Address": "vmess.example.com", "Port": "8443",
+		"Id": "uuid-2", "AlterId": "0", "Security": "aes-128-gcm", "Network": "ws",
+		"RequestHost": "cdn.example.com", "Path": "/ws", "StreamSecurity": "tls",
+		"Alpn": "h2,http/1.1", "AllowInsecure": "true",
+	})
+	ob, err := buildOutbound(p)
+	if err != nil {
+		t.Fatalf("build outbound: %v", err)
+	}
+	assertJSON(t, ob, `{
+  "protocol": "vmess",
+  "settings": {"vnext": [{"address": "vmess.example.com", "port": 8443,
+    "users": [{"id": "uuid-2", "alterId": 0, "security": "aes-128-gcm"}]}]},
+  "streamSettings": {"network": "ws", "wsSettings": {"path": "/ws", "host": "cdn.example.com"},
+    "security": "tls", "tlsSettings": {"serverName": "cdn.example.com", "alpn": ["h2", "http/1.1"], "allowInsecure": true}}
+}`)
+}
+
+func TestBuildOutboundTrojanGRPCAndShadowsocks(t *testing.T) {
+	trojan, err := buildOutbound(newProfileRow(map[string]string{
+		"ConfigType": "6", "Address": "trojan.example.com", "Port": "443", "Id": "secret",
+		"Network": "grpc", "Path": "svc", "HeaderType": "multi", "StreamSecurity": "tls", "Sni": "trojan.example.com",
+	}))
+	if err != nil {
+		t.Fatalf("build trojan outbound: %v", err)
+	}
+	assertJSON(t, trojan, `{
+  "protocol": "trojan",
+  "settings": {"servers": [{"address": "trojan.example.com", "port": 443, "password": "secret"}]},
+  "streamSettings": {"network": "grpc", "grpcSettings": {"serviceName": "svc", "multiMode": true},
+    "security": "tls", "tlsSettings": {"serverName": "trojan.example.com"}}
+}`)
+
+	ss, err := buildOutbound(newProfileRow(map[string]string{
+		"ConfigType": "3", "Address": "ss.example.com", "Port": "8388", "Id": "pw", "Security": "aes-256-gcm",
+	}))
+	if err != nil {
+		t.Fatalf("build shadowsocks outbound: %v", err)
+	}
+	assertJSON(t, ss, `{
+  "protocol": "shadowsocks",
+  "settings": {"servers": [{"address": "ss.example.com", "port": 8388, "method": "aes-256-gcm", "password": "pw"}]},
+  "streamSettings": {"network": "tcp"}
+}`)
+}
+
+func TestBuildOutboundRejectsInvalidPort(t *testing.T) {
+	if _, err := buildOutbound(newProfileRow(map[string]string{"ConfigType": "5", "Address": "a", "Port": "0"})); err == nil {
+		t.Fatal("expected invalid port error")
+	}
+}
+
+func TestBuildOutboundRejectsShadowsocksWithoutMethod(t *testing.T) {
+	if _, err := buildOutbound(newProfileRow(map[string]string{"ConfigType": "3", "Address": "a", "Port": "8388", "Id": "secret"})); err == nil {
+		t.Fatal("expected error for an empty shadowsocks method")
+	}
+}
+
+func assertJSON(t *testing.T, got any, want string) {
+	t.Helper()
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotDoc, wantDoc any
+	if err := json.Unmarshal(gotJSON, &gotDoc); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantDoc); err != nil {
+		t.Fatalf("bad expectation: %v", err)
+	}
+	wantJSON, _ := json.Marshal(wantDoc)
+	gotJSON, _ = json.Marshal(gotDoc)
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("unexpected json:\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
@@ -0,0 +1,176 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets"
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+// GeoReferences checks that geosite:, geoip: and ext: references in custom
+// and imported rules exist in the .dat files under assetDir. A .dat file that
+// has not been downloaded yet is not an error; run fetches it later.
+func GeoReferences(assetDir string, routing *config.Routing, customRules []map[string]any) error {
+	idx := &geoIndex{dir: assetDir, sites: map[string]map[string]map[string]struct{}{}, ips: map[string]map[string]struct{}{}}
+	for i, rule := range customRules {
+		where := fmt.Sprintf("custom rule #%d", i+1)
+		if err := idx.checkDomains(stringValues(rule["domain"]), where); err != nil {
+			return err
+		}
+		if err := idx.checkIPs(stringValues(rule["ip"]), where); err != nil {
+			return err
+		}
+	}
+	if routing == nil {
+		return nil
+	}
+	for _, r := range routing.Rules {
+		where := fmt.Sprintf("routing rule %q", r.Name)
+		if err := idx.checkDomains(r.Domain, where); err != nil {
+			return err
+		}
+		if err := idx.checkIPs(r.IP, where); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// geoIndex caches the categories of each .dat file. A nil entry marks a file
+// that does not exist.
+type geoIndex struct {
+	dir   string
+	sites map[string]map[string]map[string]struct{}
+	ips   map[string]map[string]struct{}
+}
+
+func (g *geoIndex) checkDomains(values []string, where string) error {
+	for _, v := range values {
+		var file, spec string
+		switch {
+		case strings.HasPrefix(v, "geosite:"):
+			file, spec = "geosite.dat", strings.TrimPrefix(v, "geosite:")
+		case strings.HasPrefix(v, "ext:"):
+			var ok bool
+			file, spec, ok = strings.Cut(strings.TrimPrefix(v, "ext:"), ":")
+			if !ok {
+				return fmt.Errorf("%s: invalid ext reference %q", where, v)
+			}
+		default:
+			continue
+		}
+		cats, err := g.siteCategories(file)
+		if err != nil {
+			return err
+		}
+		if cats == nil {
+			continue
+		}
+		parts := strings.Split(spec, "@")
+		attrs, ok := cats[strings.ToLower(parts[0])]
+		if !ok {
+			return fmt.Errorf("%s: unknown geosite category %q in %s", where, parts[0], file)
+		}
+		for _, a := range parts[1:] {
+			if _, ok := attrs[strings.ToLower(a)]; !ok {
+				return fmt.Errorf("%s: geosite category %q has no attribute @%s", where, parts[0], a)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *geoIndex) checkIPs(values []string, where string) error {
+	for _, v := range values {
+		var file, code string
+		switch {
+		case strings.HasPrefix(v, "geoip:"):
+			file, code = "geoip.dat", strings.TrimPrefix(v, "geoip:")
+		case strings.HasPrefix(v, "ext:"):
+			var ok bool
+			file, code, ok = strings.Cut(strings.TrimPrefix(v, "ext:"), ":")
+			if !ok {
+				return fmt.Errorf("%s: invalid ext reference %q", where, v)
+			}
+		default:
+			continue
+		}
+		codes, err := g.ipCodes(file)
+		if err != nil {
+			return err
+		}
+		if codes == nil {
+			continue
+		}
+		code = strings.TrimPrefix(code, "!")
+		if _, ok := codes[strings.ToLower(code)]; !ok {
+			return fmt.Errorf("%s: unknown geoip category %q in %s", where, code, file)
+		}
+	}
+	return nil
+}
+
+func (g *geoIndex) siteCategories(file string) (map[string]map[string]struct{}, error) {
+	if cats, ok := g.sites[file]; ok {
+		return cats, nil
+	}
+	list, err := assets.ListGeoSite(filepath.Join(g.dir, file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			g.sites[file] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	cats := make(map[string]map[string]struct{}, len(list))
+	for _, c := range list {
+		attrs := make(map[string]struct{}, len(c.Attrs))
+		for _, a := range c.Attrs {
+			attrs[a] = struct{}{}
+		}
+		cats[strings.ToLower(c.Code)] = attrs
+	}
+	g.sites[file] = cats
+	return cats, nil
+}
+
+func (g *geoIndex) ipCodes(file string) (map[string]struct{}, error) {
+	if codes, ok := g.ips[file]; ok {
+		return codes, nil
+	}
+	list, err := assets.ListGeoIP(filepath.Join(g.dir, file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			g.ips[file] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	codes := make(map[string]struct{}, len(list))
+	for _, c := range list {
+		codes[strings.ToLower(c)] = struct{}{}
+	}
+	g.ips[file] = codes
+	return codes, nil
+}
+
+func stringValues(v any) []string {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}
+	case []any:
+		out := make([]string, 0, len(vv))
+		for _, item := range vv {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package validate
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lkimju1/v2n-coremesh/internal/assets/geotest"
+	"github.com/lkimju1/v2n-coremesh/internal/config"
+)
+
+func writeGeoFixtures(t *testing.T, dir string) {
+	t.Helper()
+	geotest.WriteSites(t, filepath.Join(dir, "geosite.dat"),
+		geotest.Site{Code: "CN", Domains: []geotest.Domain{{Value: "ads.example.com", Attrs: []string{"ads"}}}},
+		geotest.Site{Code: "GEOLOCATION-!CN"},
+	)
+	geotest.WriteIPs(t, filepath.Join(dir, "geoip.dat"), geotest.IP{Code: "CN"}, geotest.IP{Code: "PRIVATE"})
+}
+
+func TestGeoReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeGeoFixtures(t, dir)
+
+	routing := &config.Routing{Rules: []config.RoutingRule{{
+		Name:   "ok",
+		Domain: []string{"geosite:cn@ads", "geosite:geolocation-!cn", "domain:example.com"},
+		IP:     []string{"geoip:private", "geoip:!cn", "10.0.0.0/8"},
+	}}}
+	custom := []map[string]any{{"domain": []any{"geosite:CN"}, "ip": []any{"geoip:cn"}}}
+	if err := GeoReferences(dir, routing, custom); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		routing *config.Routing
+		custom  []map[string]any
+		want    string
+	}{
+		{nil, []map[string]any{{"domain": []any{"geosite:anthropics"}}}, `custom rule #1: unknown geosite category "anthropics"`},
+		{&config.Routing{Rules: []config.RoutingRule{{Name: "r", IP: []string{"geoip:xx"}}}}, nil, `routing rule "r": unknown geoip category "xx"`},
+		{&config.Routing{Rules: []config.RoutingRule{{Name: "r", Domain: []string{"geosite:cn@cdn"}}}}, nil, "no attribute @cdn"},
+	}
+	for _, tc := range cases {
+		err := GeoReferences(dir, tc.routing, tc.custom)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected error containing %q, got %v", tc.want, err)
+		}
+	}
+}
+
+func TestGeoReferencesSkipsMissingFiles(t *testing.T) {
+	custom := []map[string]any{{"domain": []any{"geosite:anything"}, "ip": []any{"ext:company.dat:office"}}}
+	if err := GeoReferences(t.TempDir(), nil, custom); err != nil {
+		t.Fatalf("missing dat files should be skipped: %v", err)
+	}
+}
@@ -3,9 +3,13 @@ package validate
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/lkimju1/v2n-coremesh/internal/chain"
 	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
@@ -19,13 +23,21 @@ func Main(cfg *config.File, routing *config.Routing) error {
 	if cfg.Xray.Bin == "" || cfg.Xray.BaseConfig == "" {
 		return fmt.Errorf("xray.bin and xray.base_config are required")
 	}
-	if err := checkFile(cfg.Xray.Bin, "xray.bin"); err != nil {
+	if err := checkBin(cfg.Xray.Bin, "xray.bin"); err != nil {
 		return err
 	}
 	if err := checkFile(cfg.Xray.BaseConfig, "xray.base_config"); err != nil {
 		return err
 	}
+	if err := checkRestart(cfg.Xray.Restart, "xray.restart"); err != nil {
+		return err
+	}
+	// tagSet holds the tags other entries may reference. The active core
+	// (unless it gets a core inbound) and the active outbound are reached
+	// through the base config's proxy outbound and never emitted under their
+	// own tags, so they are only checked for duplicates.
 	tagSet := make(map[string]struct{})
+	seenTags := make(map[string]struct{})
 	listenSet := make(map[string]struct{})
 	for i, c := range cfg.Cores {
 		idx := fmt.Sprintf("cores[%d]", i)
@@ -39,23 +51,71 @@ func Main(cfg *config.File, routing *config.Routing) error {
 		if tag == "" {
 			return fmt.Errorf("%s.outbound_tag or %s.alias is required", idx, idx)
 		}
-		if err := checkFile(c.Bin, idx+".bin"); err != nil {
+		if strings.EqualFold(tag, reservedProcessName) {
+			return fmt.Errorf("%s: outbound_tag %q is reserved for the xray process", idx, tag)
+		}
+		if err := checkBin(c.Bin, idx+".bin"); err != nil {
 			return err
 		}
 		if err := checkFile(c.Config, idx+".config"); err != nil {
 			return err
 		}
-		if _, ok := tagSet[tag]; ok {
+		if err := checkRestart(c.Restart, idx+".restart"); err != nil {
+			return err
+		}
+		if err := checkDuration(c.Ready.Timeout, idx+".ready.timeout"); err != nil {
+			return err
+		}
+		if _, ok := seenTags[tag]; ok {
 			return fmt.Errorf("duplicate outbound_tag: %s", tag)
 		}
-		tagSet[tag] = struct{}{}
+		seenTags[tag] = struct{}{}
+		if !c.Active || cfg.CoreInbounds != nil {
+			tagSet[tag] = struct{}{}
+		}
 		key := fmt.Sprintf("%s:%d", c.Listen.Host, c.Listen.Port)
 		if _, ok := listenSet[key]; ok {
 			return fmt.Errorf("duplicate listen endpoint: %s", key)
 		}
 		listenSet[key] = struct{}{}
 	}
+	for i, ob := range cfg.Outbounds {
+		idx := fmt.Sprintf("outbounds[%d]", i)
+		tag := strings.TrimSpace(ob.Tag)
+		if tag == "" {
+			return fmt.Errorf("%s.tag is required", idx)
+		}
+		if protocol, _ := ob.Xray["protocol"].(string); strings.TrimSpace(protocol) == "" {
+			return fmt.Errorf("%s.xray.protocol is required", idx)
+		}
+		if _, ok := seenTags[tag]; ok {
+			return fmt.Errorf("duplicate outbound_tag: %s", tag)
+		}
+		seenTags[tag] = struct{}{}
+		if !ob.Active {
+			tagSet[tag] = struct{}{}
+		}
+	}
+	if err := checkCoreInbounds(cfg); err != nil {
+		return err
+	}
+	if err := CheckMode(cfg.Mode); err != nil {
+		return err
+	}
+	groupSet, err := checkGroups(cfg, tagSet)
+	if err != nil {
+		return err
+	}
+	if err := checkVias(cfg, tagSet); err != nil {
+		return err
+	}
 	for _, r := range routing.Rules {
+		if r.Group != "" {
+			if _, ok := groupSet[r.Group]; !ok {
+				return fmt.Errorf("routing rule %q references unknown group %q", r.Name, r.Group)
+			}
+			continue
+		}
 		if isBuiltinOutboundTag(r.OutboundTag) {
 			continue
 		}
@@ -70,9 +130,164 @@ func Main(cfg *config.File, routing *config.Routing) error {
 			}
 		}
 	}
+	if routing.GlobalOutboundTag != "" {
+		if !isBuiltinOutboundTag(routing.GlobalOutboundTag) {
+			if _, ok := tagSet[routing.GlobalOutboundTag]; !ok {
+				return fmt.Errorf("global_outbound_tag %q not found", routing.GlobalOutboundTag)
+			}
+		}
+	}
 	return os.MkdirAll(filepath.Dir(cfg.App.GeneratedXrayConfig), 0o755)
 }
 
+// checkGroups validates the core groups against the known outbound tags and
+// returns the group names.
+func checkGroups(cfg *config.File, tagSet map[string]struct{}) (map[string]struct{}, error) {
+	known := func(tag string) bool {
+		_, ok := tagSet[tag]
+		return ok || isBuiltinOutboundTag(tag)
+	}
+	groupSet := make(map[string]struct{}, len(cfg.Groups))
+	for i, g := range cfg.Groups {
+		idx := fmt.Sprintf("groups[%d]", i)
+		name := strings.TrimSpace(g.Name)
+		if name == "" {
+			return nil, fmt.Errorf("%s.name is required", idx)
+		}
+		if _, ok := groupSet[name]; ok {
+			return nil, fmt.Errorf("duplicate group: %s", name)
+		}
+		if known(name) {
+			return nil, fmt.Errorf("group %s has the same name as an outbound", name)
+		}
+		groupSet[name] = struct{}{}
+		if len(g.Members) == 0 {
+			return nil, fmt.Errorf("group %s has no members", name)
+		}
+		for _, m := range g.Members {
+			if !known(m) {
+				return nil, fmt.Errorf("group %s references unknown outbound %q", name, m)
+			}
+		}
+		if err := checkMemberPrefixes(name, g.Members, tagSet); err != nil {
+			return nil, err
+		}
+		if g.Strategy != "" && !slices.Contains(config.Strategies, g.Strategy) {
+			return nil, fmt.Errorf("group %s: unknown strategy %q (want %s)", name, g.Strategy, strings.Join(config.Strategies, ", "))
+		}
+		if g.Fallback != "" && !known(g.Fallback) {
+			return nil, fmt.Errorf("group %s: unknown fallback outbound %q", name, g.Fallback)
+		}
+	}
+	if u := strings.TrimSpace(cfg.Probe.URL); u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return nil, fmt.Errorf("probe.url must be an http(s) URL, got %q", u)
+	}
+	if err := checkDuration(cfg.Probe.Interval, "probe.interval"); err != nil {
+		return nil, err
+	}
+	return groupSet, nil
+}
+
+// checkMemberPrefixes rejects a member that is a prefix of another outbound
+// tag outside the group: xray selects balancer members by tag prefix, so the
+// group would silently include that outbound too.
+func checkMemberPrefixes(group string, members []string, tagSet map[string]struct{}) error {
+	tags := make([]string, 0, len(tagSet)+3)
+	for tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	tags = append(tags, "direct", "block", "proxy")
+	slices.Sort(tags)
+	for _, m := range members {
+		for _, tag := range tags {
+			if tag != m && strings.HasPrefix(tag, m) && !slices.Contains(members, tag) {
+				return fmt.Errorf("group %s: member %q would also select outbound %q (xray matches members as tag prefixes); add it to the group or rename one of them", group, m, tag)
+			}
+		}
+	}
+	return nil
+}
+
+// checkVias makes sure every via names a known outbound, that the configs
+// of chained cores can be patched and that no chain loops.
+func checkVias(cfg *config.File, tagSet map[string]struct{}) error {
+	vias, err := cfg.Vias()
+	if err != nil {
+		return err
+	}
+	coreSet := make(map[string]config.Core, len(cfg.Cores))
+	for _, c := range cfg.Cores {
+		coreSet[c.Tag()] = c
+	}
+	tags := make([]string, 0, len(vias))
+	for tag := range vias {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		via := vias[tag]
+		c, isCore := coreSet[tag]
+		kind := "outbound"
+		if isCore {
+			kind = "core"
+		}
+		if _, ok := coreSet[via]; !ok {
+			if _, ok := tagSet[via]; !ok && !isBuiltinOutboundTag(via) {
+				return fmt.Errorf("%s %s: via references unknown outbound %q", kind, tag, via)
+			}
+		}
+		if isCore {
+			if err := chain.CheckConfig(c.Config); err != nil {
+				return fmt.Errorf("core %s: cannot chain through %s: %w", tag, via, err)
+			}
+		}
+		path := []string{tag}
+		for next, ok := via, true; ok; next, ok = vias[next] {
+			path = append(path, next)
+			if slices.Contains(path[:len(path)-1], next) {
+				return fmt.Errorf("via chain loops: %s", strings.Join(path, " -> "))
+			}
+		}
+	}
+	return nil
+}
+
+// CheckMode accepts an empty mode (rule) or one of config.Modes.
+func CheckMode(mode string) error {
+	if mode == "" || slices.Contains(config.Modes, mode) {
+		return nil
+	}
+	return fmt.Errorf("unknown routing mode %q (want %s)", mode, strings.Join(config.Modes, ", "))
+}
+
+// checkCoreInbounds validates the per-core inbound settings and makes sure
+// their port range does not overlap a core listener.
+func checkCoreInbounds(cfg *config.File) error {
+	ci := cfg.CoreInbounds
+	if ci == nil {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(ci.Protocol)) {
+	case "", "socks", "mixed":
+	default:
+		return fmt.Errorf("core_inbounds.protocol must be socks or mixed, got %q", ci.Protocol)
+	}
+	last := ci.BasePort + len(cfg.Cores) - 1
+	if ci.BasePort <= 0 || last > 65535 {
+		return fmt.Errorf("core_inbounds.base_port %d leaves no room for %d cores", ci.BasePort, len(cfg.Cores))
+	}
+	for _, c := range cfg.Cores {
+		if c.Listen.Port >= ci.BasePort && c.Listen.Port <= last {
+			return fmt.Errorf("core_inbounds ports %d-%d overlap core %s listening on %d", ci.BasePort, last, c.Name, c.Listen.Port)
+		}
+	}
+	return nil
+}
+
+// reservedProcessName is the control API name of xray; cores are named by
+// their outbound tag.
+const reservedProcessName = "xray"
+
 func ForRun(cfg *config.File) error {
 	if cfg.App.GeneratedXrayConfig == "" {
 		return fmt.Errorf("app.generated_xray_config is required")
@@ -80,27 +295,48 @@ func ForRun(cfg *config.File) error {
 	if cfg.Xray.Bin == "" {
 		return fmt.Errorf("xray.bin is required")
 	}
-	if err := checkFile(cfg.Xray.Bin, "xray.bin"); err != nil {
+	if err := checkBin(cfg.Xray.Bin, "xray.bin"); err != nil {
 		return err
 	}
 	if err := checkFile(cfg.App.GeneratedXrayConfig, "app.generated_xray_config"); err != nil {
 		return err
 	}
+	if err := checkRestart(cfg.Xray.Restart, "xray.restart"); err != nil {
+		return err
+	}
 	for i, c := range cfg.Cores {
 		idx := fmt.Sprintf("cores[%d]", i)
 		if strings.TrimSpace(c.Name) == "" {
 			return fmt.Errorf("%s.name is required", idx)
 		}
-		if err := checkFile(c.Bin, idx+".bin"); err != nil {
+		if err := checkBin(c.Bin, idx+".bin"); err != nil {
 			return err
 		}
 		if err := checkFile(c.Config, idx+".config"); err != nil {
 			return err
 		}
+		if err := checkRestart(c.Restart, idx+".restart"); err != nil {
+			return err
+		}
+		if err := checkDuration(c.Ready.Timeout, idx+".ready.timeout"); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// checkBin accepts a path to an executable file or a bare command name that
+// resolves through PATH.
+func checkBin(path, field string) error {
+	if path != "" && !strings.ContainsAny(path, `/\`) {
+		if _, err := exec.LookPath(path); err != nil {
+			return fmt.Errorf("%s invalid: %w", field, err)
+		}
+		return nil
+	}
+	return checkFile(path, field)
+}
+
 func checkFile(path, field string) error {
 	if path == "" {
 		return fmt.Errorf("%s is required", field)
@@ -115,6 +351,36 @@ func checkFile(path, field string) error {
 	return nil
 }
 
+func checkRestart(r config.Restart, field string) error {
+	switch strings.ToLower(strings.TrimSpace(r.Policy)) {
+	case "", "never", "on-failure", "always":
+	default:
+		return fmt.Errorf("%s.policy must be never, on-failure or always: %q", field, r.Policy)
+	}
+	if r.MaxRetries < 0 {
+		return fmt.Errorf("%s.max_retries must not be negative", field)
+	}
+	if err := checkDuration(r.Backoff, field+".backoff"); err != nil {
+		return err
+	}
+	return checkDuration(r.MaxBackoff, field+".max_backoff")
+}
+
+func checkDuration(v, field string) error {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s invalid: %w", field, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive", field)
+	}
+	return nil
+}
+
 func isBuiltinOutboundTag(tag string) bool {
 	switch strings.ToLower(strings.TrimSpace(tag)) {
 	case "direct", "block", "proxy":
@@ -3,6 +3,8 @@ package validate
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/lkimju1/v2n-coremesh/internal/config"
@@ -50,6 +52,155 @@ func TestMainDuplicatePort(t *testing.T) {
 	if err := Main(cfg, routing); err == nil {
 		t.Fatal("expected duplicate endpoint error")
 	}
+
+	cfg.Cores = cfg.Cores[:1]
+	cfg.Cores[0].OutboundTag, cfg.Cores[0].Alias = "", "xray"
+	if err := Main(cfg, routing); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("expected reserved name error, got %v", err)
+	}
+}
+
+func TestMainCoreInbounds(t *testing.T) {
+	tmp := t.TempDir()
+	xrayBin := touchFile(t, tmp, "xray")
+	xrayBase := touchFile(t, tmp, "xray.base.json")
+	coreBin := touchFile(t, tmp, "core")
+	coreCfg := touchFile(t, tmp, "core.json")
+
+	cfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: filepath.Join(tmp, "runtime", "xray.generated.json")},
+		Xray: config.Xray{Bin: xrayBin, BaseConfig: xrayBase},
+		Cores: []config.Core{
+			{Name: "c1", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10001}, OutboundTag: "o1"},
+			{Name: "c2", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10002}, OutboundTag: "o2"},
+		},
+	}
+	for _, tc := range []struct {
+		ci      config.CoreInbounds
+		wantErr bool
+	}{
+		{config.CoreInbounds{BasePort: 20001}, false},
+		{config.CoreInbounds{BasePort: 20001, Protocol: "mixed"}, false},
+		{config.CoreInbounds{BasePort: 20001, Protocol: "http"}, true},
+		{config.CoreInbounds{BasePort: 10000}, true},
+		{config.CoreInbounds{BasePort: 65535}, true},
+	} {
+		ci := tc.ci
+		cfg.CoreInbounds = &ci
+		if err := Main(cfg, nil); (err != nil) != tc.wantErr {
+			t.Fatalf("core_inbounds %+v: err = %v, want error %t", ci, err, tc.wantErr)
+		}
+	}
+}
+
+func TestMainGroups(t *testing.T) {
+	tmp := t.TempDir()
+	xrayBin := touchFile(t, tmp, "xray")
+	xrayBase := touchFile(t, tmp, "xray.base.json")
+	coreBin := touchFile(t, tmp, "core")
+	coreCfg := touchFile(t, tmp, "core.json")
+
+	cfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: filepath.Join(tmp, "runtime", "xray.generated.json")},
+		Xray: config.Xray{Bin: xrayBin, BaseConfig: xrayBase},
+		Cores: []config.Core{
+			{Name: "c1", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10001}, OutboundTag: "o1"},
+			{Name: "c2", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10002}, OutboundTag: "o2"},
+			{Name: "c3", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10003}, OutboundTag: "o2-2"},
+		},
+		Outbounds: []config.Outbound{{Name: "act", Tag: "act", Active: true, Xray: map[string]any{"protocol": "vless"}}},
+	}
+	routing := &config.Routing{Rules: []config.RoutingRule{{Name: "r1", Domain: []string{"domain:example.com"}, Group: "pool"}}}
+	for _, tc := range []struct {
+		group   config.Group
+		wantErr bool
+	}{
+		{config.Group{Name: "pool", Members: []string{"o1", "o2", "o2-2"}, Strategy: config.StrategyLeastLoad, Fallback: "direct"}, false},
+		{config.Group{Name: "pool", Members: []string{"o1", "o2"}}, true},
+		{config.Group{Name: "pool", Members: []string{"o2-2"}}, false},
+		{config.Group{Name: "other", Members: []string{"o1"}}, true},
+		{config.Group{Name: "pool", Members: []string{"o1", "o3"}}, true},
+		{config.Group{Name: "pool", Members: []string{"o1"}, Strategy: "fastest"}, true},
+		{config.Group{Name: "o1", Members: []string{"o2"}}, true},
+		{config.Group{Name: "pool", Members: []string{"o1", "act"}}, true},
+		{config.Group{Name: "pool", Members: []string{"o1", "proxy"}}, false},
+	} {
+		cfg.Groups = []config.Group{tc.group}
+		if err := Main(cfg, routing); (err != nil) != tc.wantErr {
+			t.Fatalf("group %+v: err = %v, want error %t", tc.group, err, tc.wantErr)
+		}
+	}
+
+	// The active core has an outbound only when it gets a core inbound.
+	cfg.Cores[0].Active = true
+	cfg.Groups = []config.Group{{Name: "pool", Members: []string{"o1", "o2", "o2-2"}}}
+	if err := Main(cfg, routing); err == nil {
+		t.Fatal("expected an error for a group with the active core")
+	}
+	cfg.CoreInbounds = &config.CoreInbounds{BasePort: 20001}
+	if err := Main(cfg, routing); err != nil {
+		t.Fatalf("active core with a core inbound: %v", err)
+	}
+}
+
+func TestMainVias(t *testing.T) {
+	tmp := t.TempDir()
+	xrayBin := touchFile(t, tmp, "xray")
+	xrayBase := touchFile(t, tmp, "xray.base.json")
+	coreBin := touchFile(t, tmp, "core")
+	coreCfg := touchFile(t, tmp, "core.json")
+	singBoxCfg := filepath.Join(tmp, "tuic.json")
+	if err := os.WriteFile(singBoxCfg, []byte(`{"outbounds":[{"type":"tuic","tag":"tuic-out"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newConfig := func() *config.File {
+		return &config.File{
+			App:  config.App{GeneratedXrayConfig: filepath.Join(tmp, "runtime", "xray.generated.json")},
+			Xray: config.Xray{Bin: xrayBin, BaseConfig: xrayBase},
+			Cores: []config.Core{
+				{Name: "naive", Bin: coreBin, Config: coreCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10001}, OutboundTag: "naive"},
+				{Name: "tuic", Bin: coreBin, Config: singBoxCfg, Listen: config.Listen{Host: "127.0.0.1", Port: 10002}, OutboundTag: "tuic", Via: "naive"},
+			},
+			Outbounds: []config.Outbound{{Name: "vless", Tag: "vless", Via: "tuic", Xray: map[string]any{"protocol": "vless"}}},
+		}
+	}
+	if err := Main(newConfig(), nil); err != nil {
+		t.Fatalf("valid chain rejected: %v", err)
+	}
+
+	cfg := newConfig()
+	cfg.Cores[0].Via = "tuic"
+	cfg.Cores[0].Config = singBoxCfg
+	if err := Main(cfg, nil); err == nil || !strings.Contains(err.Error(), "loops") {
+		t.Fatalf("expected a loop error, got %v", err)
+	}
+
+	cfg = newConfig()
+	cfg.Cores[1].Config = coreCfg
+	if err := Main(cfg, nil); err == nil || !strings.Contains(err.Error(), "cannot chain") {
+		t.Fatalf("expected error for a config without outbounds, got %v", err)
+	}
+
+	cfg = newConfig()
+	cfg.Cores[1].Via = "vless"
+	cfg.Outbounds[0].Via = ""
+	if err := Main(cfg, nil); err != nil {
+		t.Fatalf("core chained through an xray outbound rejected: %v", err)
+	}
+	cfg.Cores[1].Via = "vmess"
+	if err := Main(cfg, nil); err == nil || !strings.Contains(err.Error(), "unknown outbound") {
+		t.Fatalf("expected error for an unknown via, got %v", err)
+	}
+
+	cfg = newConfig()
+	cfg.Groups = []config.Group{
+		{Name: "a", Members: []string{"naive"}, Via: "vless"},
+		{Name: "b", Members: []string{"naive"}, Via: "tuic"},
+	}
+	if err := Main(cfg, nil); err == nil || !strings.Contains(err.Error(), "group") {
+		t.Fatalf("expected error for conflicting group vias, got %v", err)
+	}
 }
 
 func TestForRunChecksGeneratedConfig(t *testing.T) {
@@ -71,6 +222,49 @@ func TestForRunChecksGeneratedConfig(t *testing.T) {
 	}
 }
 
+func TestCheckBinLooksUpBareNames(t *testing.T) {
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "fake-xray"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", tmp)
+	if runtime.GOOS != "windows" {
+		if err := checkBin("fake-xray", "xray.bin"); err != nil {
+			t.Fatalf("bare name in PATH should be accepted: %v", err)
+		}
+	}
+	if err := checkBin("missing-xray", "xray.bin"); err == nil {
+		t.Fatal("expected error for a command missing from PATH")
+	}
+	if err := checkBin(filepath.Join(tmp, "missing"), "xray.bin"); err == nil {
+		t.Fatal("expected error for a missing path")
+	}
+}
+
+func TestForRunRejectsInvalidRestartPolicy(t *testing.T) {
+	tmp := t.TempDir()
+	xrayBin := touchFile(t, tmp, "xray")
+	coreBin := touchFile(t, tmp, "core")
+	coreCfg := touchFile(t, tmp, "core.json")
+	generated := touchFile(t, tmp, "xray.generated.json")
+
+	cfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: generated},
+		Xray: config.Xray{Bin: xrayBin},
+		Cores: []config.Core{
+			{Name: "c1", Bin: coreBin, Config: coreCfg, Restart: config.Restart{Policy: "sometimes"}},
+		},
+	}
+	if err := ForRun(cfg); err == nil {
+		t.Fatal("expected invalid restart policy error")
+	}
+
+	cfg.Cores[0].Restart = config.Restart{Policy: "on-failure", Backoff: "soon"}
+	if err := ForRun(cfg); err == nil {
+		t.Fatal("expected invalid backoff error")
+	}
+}
+
 func touchFile(t *testing.T, dir, name string) string {
 	t.Helper()
 	p := filepath.Join(dir, name)
@@ -4,11 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/lkimju1/v2n-coremesh/internal/config"
 )
 
+// Probe defaults for group health checks.
+const (
+	defaultProbeURL      = "https://www.google.com/generate_204"
+	defaultProbeInterval = "1m"
+)
+
 func Generate(mainCfg *config.File, routingCfg *config.Routing, customRules []map[string]any) error {
 	if routingCfg == nil {
 		routingCfg = &config.Routing{}
@@ -24,16 +31,19 @@ func Generate(mainCfg *config.File, routingCfg *config.Routing, customRules []ma
 		return fmt.Errorf("parse xray base config: %w", err)
 	}
 
+	vias, err := mainCfg.Vias()
+	if err != nil {
+		return err
+	}
 	outbounds := ensureArray(doc, "outbounds")
 	existingTags := collectOutboundTags(outbounds)
 	for _, c := range mainCfg.Cores {
-		if c.Active {
+		// The active core is normally reached through the base config's own
+		// proxy outbound; a dedicated inbound needs it addressable by tag.
+		if c.Active && mainCfg.CoreInbounds == nil {
 			continue
 		}
-		tag := strings.TrimSpace(c.OutboundTag)
-		if tag == "" {
-			tag = strings.TrimSpace(c.Alias)
-		}
+		tag := coreTag(c)
 		if tag == "" {
 			continue
 		}
@@ -52,23 +62,80 @@ func Generate(mainCfg *config.File, routingCfg *config.Routing, customRules []ma
 		})
 		existingTags[tag] = struct{}{}
 	}
+	for _, ob := range mainCfg.Outbounds {
+		if ob.Active {
+			continue
+		}
+		tag := strings.TrimSpace(ob.Tag)
+		if tag == "" {
+			continue
+		}
+		if _, exists := existingTags[tag]; exists {
+			continue
+		}
+		outbound := make(map[string]any, len(ob.Xray)+1)
+		for k, v := range ob.Xray {
+			outbound[k] = v
+		}
+		outbound["tag"] = tag
+		if via, ok := vias[tag]; ok {
+			setDialerProxy(outbound, via)
+		}
+		outbounds = append(outbounds, outbound)
+		existingTags[tag] = struct{}{}
+	}
 	doc["outbounds"] = outbounds
 
+	coreRules, err := addCoreInbounds(doc, mainCfg)
+	if err != nil {
+		return err
+	}
+	coreRules = append(coreRules, addViaInbounds(doc, mainCfg, vias)...)
+	addObservatories(doc, mainCfg)
+
+	mode := mainCfg.Mode
+	if mode == "" {
+		mode = config.ModeRule
+	}
+	fallback, err := fallbackRule(mode, routingCfg, outbounds)
+	if err != nil {
+		return err
+	}
+
 	routing := ensureObject(doc, "routing")
 	baseRules := ensureArrayFromObject(routing, "rules")
-	rules := make([]any, 0, len(customRules)+len(routingCfg.Rules)+len(baseRules))
-	for _, rule := range customRules {
-		rules = append(rules, rule)
+	rules := make([]any, 0, len(coreRules)+len(customRules)+len(routingCfg.Rules)+len(baseRules)+1)
+	rules = append(rules, coreRules...)
+	if mode == config.ModeRule {
+		for _, rule := range customRules {
+			rules = append(rules, rule)
+		}
+		for _, r := range routingCfg.Rules {
+			rules = append(rules, ruleObject(r))
+		}
+		rules = append(rules, baseRules...)
+	} else {
+		// Rules that only route a base inbound (stats API, DNS) still apply.
+		for _, rule := range baseRules {
+			if m, ok := rule.(map[string]any); ok && inboundOnly(m) {
+				rules = append(rules, m)
+			}
+		}
 	}
-	for _, r := range routingCfg.Rules {
-		rules = append(rules, map[string]any{
-			"type":        "field",
-			"domain":      r.Domain,
-			"outboundTag": r.OutboundTag,
-		})
+	if fallback != nil {
+		rules = append(rules, fallback)
 	}
-	rules = append(rules, baseRules...)
 	routing["rules"] = rules
+	balancers := ensureArrayFromObject(routing, "balancers")
+	for _, g := range mainCfg.Groups {
+		balancers = append(balancers, balancerObject(g))
+	}
+	if len(balancers) > 0 {
+		routing["balancers"] = balancers
+	}
+	if err := checkBalancerTags(rules, balancers); err != nil {
+		return err
+	}
 	doc["routing"] = routing
 
 	result, err := json.MarshalIndent(doc, "", "  ")
@@ -81,6 +148,385 @@ func Generate(mainCfg *config.File, routingCfg *config.Routing, customRules []ma
 	return nil
 }
 
+// fallbackRule returns the catch-all rule that ends the routing table for
+// mode, or nil in rule mode without a default outbound.
+func fallbackRule(mode string, routingCfg *config.Routing, outbounds []any) (map[string]any, error) {
+	var tag string
+	switch mode {
+	case config.ModeRule:
+		tag = strings.TrimSpace(routingCfg.DefaultOutboundTag)
+		if tag == "" {
+			return nil, nil
+		}
+	case config.ModeGlobal:
+		tag = strings.TrimSpace(routingCfg.GlobalOutboundTag)
+		if tag == "" {
+			tag = "proxy"
+		}
+	case config.ModeDirect:
+		for _, raw := range outbounds {
+			m, _ := raw.(map[string]any)
+			if protocol, _ := m["protocol"].(string); strings.EqualFold(protocol, "freedom") {
+				tag, _ = m["tag"].(string)
+				break
+			}
+		}
+		if tag == "" {
+			return nil, fmt.Errorf("direct mode needs a freedom outbound in the xray base config")
+		}
+	default:
+		return nil, fmt.Errorf("unknown routing mode %q", mode)
+	}
+	if _, ok := collectOutboundTags(outbounds)[tag]; !ok {
+		return nil, fmt.Errorf("%s mode: outbound %q not found in the generated config", mode, tag)
+	}
+	return map[string]any{
+		"type":        "field",
+		"network":     "tcp,udp",
+		"outboundTag": tag,
+	}, nil
+}
+
+// setDialerProxy sends the connections of outbound through the outbound
+// tagged via. The nested settings are copied, not shared with the config.
+func setDialerProxy(outbound map[string]any, via string) {
+	stream := make(map[string]any)
+	if src, ok := outbound["streamSettings"].(map[string]any); ok {
+		for k, v := range src {
+			stream[k] = v
+		}
+	}
+	sockopt := make(map[string]any)
+	if src, ok := stream["sockopt"].(map[string]any); ok {
+		for k, v := range src {
+			sockopt[k] = v
+		}
+	}
+	sockopt["dialerProxy"] = via
+	stream["sockopt"] = sockopt
+	outbound["streamSettings"] = stream
+}
+
+func balancerObject(g config.Group) map[string]any {
+	strategy := g.Strategy
+	if strategy == "" {
+		strategy = config.StrategyRandom
+	}
+	balancer := map[string]any{
+		"tag":      g.Name,
+		"selector": g.Members,
+		"strategy": map[string]any{"type": strategy},
+	}
+	if g.Fallback != "" {
+		balancer["fallbackTag"] = g.Fallback
+	}
+	return balancer
+}
+
+// addObservatories health-checks group members: leastPing reads the
+// observatory, the other strategies the burst observatory. Existing blocks
+// of the base config keep their settings and gain the new subjects.
+func addObservatories(doc map[string]any, mainCfg *config.File) {
+	probeURL := strings.TrimSpace(mainCfg.Probe.URL)
+	if probeURL == "" {
+		probeURL = defaultProbeURL
+	}
+	interval := strings.TrimSpace(mainCfg.Probe.Interval)
+	if interval == "" {
+		interval = defaultProbeInterval
+	}
+	var pinged, burst []string
+	for _, g := range mainCfg.Groups {
+		if g.Strategy == config.StrategyLeastPing {
+			pinged = append(pinged, g.Members...)
+		} else {
+			burst = append(burst, g.Members...)
+		}
+	}
+	if len(pinged) > 0 {
+		addSubjects(doc, "observatory", pinged, map[string]any{
+			"probeUrl":          probeURL,
+			"probeInterval":     interval,
+			"enableConcurrency": true,
+		})
+	}
+	if len(burst) > 0 {
+		addSubjects(doc, "burstObservatory", burst, map[string]any{
+			"pingConfig": map[string]any{
+				"destination": probeURL,
+				"interval":    interval,
+				"timeout":     "5s",
+				"sampling":    3,
+			},
+		})
+	}
+}
+
+func addSubjects(doc map[string]any, key string, subjects []string, defaults map[string]any) {
+	obs, ok := doc[key].(map[string]any)
+	if !ok {
+		obs = defaults
+	}
+	selector := ensureArrayFromObject(obs, "subjectSelector")
+	seen := make(map[string]struct{}, len(selector))
+	for _, v := range selector {
+		if tag, ok := v.(string); ok {
+			seen[tag] = struct{}{}
+		}
+	}
+	for _, tag := range subjects {
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		selector = append(selector, tag)
+	}
+	obs["subjectSelector"] = selector
+	doc[key] = obs
+}
+
+// checkBalancerTags makes sure every balancerTag, e.g. from a custom rule,
+// names a balancer.
+func checkBalancerTags(rules, balancers []any) error {
+	tags := make(map[string]struct{}, len(balancers))
+	for _, raw := range balancers {
+		if b, ok := raw.(map[string]any); ok {
+			if tag, ok := b["tag"].(string); ok {
+				tags[tag] = struct{}{}
+			}
+		}
+	}
+	for i, raw := range rules {
+		rule, _ := raw.(map[string]any)
+		tag, ok := rule["balancerTag"].(string)
+		if !ok {
+			continue
+		}
+		if _, known := tags[tag]; !known {
+			return fmt.Errorf("routing rule #%d references unknown balancer or group %q", i+1, tag)
+		}
+	}
+	return nil
+}
+
+// inboundOnly reports a rule that matches on inbound tags alone.
+func inboundOnly(rule map[string]any) bool {
+	if _, ok := rule["inboundTag"]; !ok {
+		return false
+	}
+	for key := range rule {
+		switch key {
+		case "type", "inboundTag", "outboundTag", "balancerTag", "ruleTag":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CoreInboundTag is the tag of the dedicated inbound for a core outbound.
+func CoreInboundTag(outboundTag string) string {
+	return config.CoreInboundTagPrefix + outboundTag
+}
+
+// addCoreInbounds appends one inbound per core when core_inbounds is set and
+// returns the rules that pin each of them to its core. They go ahead of every
+// other rule so no domain or IP rule can divert the traffic.
+func addCoreInbounds(doc map[string]any, mainCfg *config.File) ([]any, error) {
+	ci := mainCfg.CoreInbounds
+	if ci == nil {
+		return nil, nil
+	}
+	protocol := strings.ToLower(strings.TrimSpace(ci.Protocol))
+	if protocol == "" {
+		protocol = "socks"
+	}
+	listen := strings.TrimSpace(ci.Listen)
+	if listen == "" {
+		listen = "127.0.0.1"
+	}
+
+	inbounds := ensureArray(doc, "inbounds")
+	usedPorts := make(map[int]string, len(inbounds))
+	for _, raw := range inbounds {
+		inbound, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		if port, ok := inbound["port"].(float64); ok {
+			tag, _ := inbound["tag"].(string)
+			usedPorts[int(port)] = tag
+		}
+	}
+
+	var rules []any
+	for i, c := range mainCfg.Cores {
+		tag := coreTag(c)
+		if tag == "" {
+			continue
+		}
+		port := ci.BasePort + i
+		if other, used := usedPorts[port]; used {
+			return nil, fmt.Errorf("core inbound port %d for %s is already used by inbound %q", port, tag, other)
+		}
+		inboundTag := CoreInboundTag(tag)
+		inbounds = append(inbounds, map[string]any{
+			"tag":      inboundTag,
+			"protocol": protocol,
+			"listen":   listen,
+			"port":     port,
+			"settings": map[string]any{"auth": "noauth", "udp": true},
+		})
+		rules = append(rules, map[string]any{
+			"type":        "field",
+			"inboundTag":  []string{inboundTag},
+			"outboundTag": tag,
+		})
+	}
+	doc["inbounds"] = inbounds
+	return rules, nil
+}
+
+// ViaInboundTag is the tag of the inbound chained cores use to reach an
+// xray outbound.
+func ViaInboundTag(outboundTag string) string {
+	return config.ViaInboundTagPrefix + outboundTag
+}
+
+// addViaInbounds appends a loopback socks inbound for every xray outbound a
+// core chains through and returns the rules that pin each of them to its
+// outbound. Ports are taken from via_base_port upwards, skipping the ports of
+// other inbounds and core listeners.
+func addViaInbounds(doc map[string]any, mainCfg *config.File, vias map[string]string) []any {
+	targets := viaTargets(mainCfg, vias)
+	if len(targets) == 0 {
+		return nil
+	}
+	usedPorts := make(map[int]struct{})
+	for _, c := range mainCfg.Cores {
+		usedPorts[c.Listen.Port] = struct{}{}
+	}
+	inbounds := ensureArray(doc, "inbounds")
+	for _, raw := range inbounds {
+		inbound, _ := raw.(map[string]any)
+		switch port := inbound["port"].(type) {
+		case float64:
+			usedPorts[int(port)] = struct{}{}
+		case int:
+			usedPorts[port] = struct{}{}
+		}
+	}
+	port := mainCfg.ViaBasePort
+	if port == 0 {
+		port = config.DefaultViaBasePort
+	}
+	var rules []any
+	for _, via := range targets {
+		for {
+			if _, used := usedPorts[port]; !used {
+				break
+			}
+			port++
+		}
+		usedPorts[port] = struct{}{}
+		inboundTag := ViaInboundTag(via)
+		inbounds = append(inbounds, map[string]any{
+			"tag":      inboundTag,
+			"protocol": "socks",
+			"listen":   "127.0.0.1",
+			"port":     port,
+			"settings": map[string]any{"auth": "noauth", "udp": true},
+		})
+		rules = append(rules, map[string]any{
+			"type":        "field",
+			"inboundTag":  []string{inboundTag},
+			"outboundTag": via,
+		})
+	}
+	doc["inbounds"] = inbounds
+	return rules
+}
+
+// viaTargets lists, in core order, the xray outbounds that cores chain
+// through.
+func viaTargets(mainCfg *config.File, vias map[string]string) []string {
+	coreSet := make(map[string]struct{}, len(mainCfg.Cores))
+	for _, c := range mainCfg.Cores {
+		coreSet[coreTag(c)] = struct{}{}
+	}
+	var targets []string
+	for _, c := range mainCfg.Cores {
+		via, ok := vias[coreTag(c)]
+		if !ok {
+			continue
+		}
+		if _, isCore := coreSet[via]; isCore || slices.Contains(targets, via) {
+			continue
+		}
+		targets = append(targets, via)
+	}
+	return targets
+}
+
+// PinnedRules is the number of leading rules Generate adds to pin its
+// per-core and via inbounds.
+func PinnedRules(mainCfg *config.File) (int, error) {
+	vias, err := mainCfg.Vias()
+	if err != nil {
+		return 0, err
+	}
+	n := len(viaTargets(mainCfg, vias))
+	if mainCfg.CoreInbounds != nil {
+		for _, c := range mainCfg.Cores {
+			if coreTag(c) != "" {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+func coreTag(c config.Core) string {
+	tag := strings.TrimSpace(c.OutboundTag)
+	if tag == "" {
+		tag = strings.TrimSpace(c.Alias)
+	}
+	return tag
+}
+
+func ruleObject(r config.RoutingRule) map[string]any {
+	rule := map[string]any{
+		"type":        "field",
+		"outboundTag": r.OutboundTag,
+	}
+	if r.Group != "" {
+		delete(rule, "outboundTag")
+		rule["balancerTag"] = r.Group
+	}
+	if len(r.Domain) > 0 {
+		rule["domain"] = r.Domain
+	}
+	if len(r.IP) > 0 {
+		rule["ip"] = r.IP
+	}
+	if r.Port != "" {
+		rule["port"] = r.Port
+	}
+	if r.Network != "" {
+		rule["network"] = r.Network
+	}
+	if len(r.Protocol) > 0 {
+		rule["protocol"] = r.Protocol
+	}
+	if len(r.InboundTag) > 0 {
+		rule["inboundTag"] = r.InboundTag
+	}
+	if len(r.Process) > 0 {
+		rule["process"] = r.Process
+	}
+	return rule
+}
+
 func ensureArray(doc map[string]any, key string) []any {
 	if v, ok := doc[key]; ok {
 		if vv, ok := v.([]any); ok {
@@ -99,6 +99,76 @@ func TestGenerateInjectsOutboundsAndRules(t *testing.T) {
 	}
 }
 
+func TestGenerateAppendsImportedOutbounds(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	if err := os.WriteFile(basePath, []byte(`{"outbounds":[{"protocol":"freedom","tag":"direct"}],"routing":{"rules":[]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainCfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: outPath},
+		Xray: config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{
+			{Name: "c1", Alias: "core-a", OutboundTag: "core-a", Listen: config.Listen{Host: "127.0.0.1", Port: 11080}},
+		},
+		Outbounds: []config.Outbound{
+			{Name: "vless B", Tag: "vless-b", Xray: map[string]any{"protocol": "vless", "settings": map[string]any{}}},
+			{Name: "active", Tag: "active", Active: true, Xray: map[string]any{"protocol": "trojan"}},
+		},
+	}
+	routingCfg := &config.Routing{
+		Rules: []config.RoutingRule{{Name: "vless B", Domain: []string{"domain:b.example.com"}, OutboundTag: "vless-b"}},
+	}
+	if err := Generate(mainCfg, routingCfg, nil); err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	outbounds := doc["outbounds"].([]any)
+	if len(outbounds) != 3 || !hasTag(outbounds, "core-a") || !hasTag(outbounds, "vless-b") {
+		t.Fatalf("unexpected outbounds: %#v", outbounds)
+	}
+	if hasTag(outbounds, "active") {
+		t.Fatalf("active outbound should not be injected: %#v", outbounds)
+	}
+	last := outbounds[2].(map[string]any)
+	if last["protocol"] != "vless" || last["tag"] != "vless-b" {
+		t.Fatalf("imported outbound should keep its protocol: %#v", last)
+	}
+	if _, ok := mainCfg.Outbounds[0].Xray["tag"]; ok {
+		t.Fatal("generate should not mutate the imported outbound")
+	}
+}
+
+func TestRuleObjectEmitsAllMatchers(t *testing.T) {
+	rule := ruleObject(config.RoutingRule{
+		Name:        "full",
+		IP:          []string{"geoip:private"},
+		Port:        "443,8000-9000",
+		Network:     "tcp,udp",
+		Protocol:    []string{"tls"},
+		InboundTag:  []string{"socks-in"},
+		Process:     []string{"curl"},
+		OutboundTag: "direct",
+	})
+	if _, ok := rule["domain"]; ok {
+		t.Fatalf("empty domain should be omitted: %#v", rule)
+	}
+	for _, key := range []string{"ip", "port", "network", "protocol", "inboundTag", "process", "outboundTag", "type"} {
+		if _, ok := rule[key]; !ok {
+			t.Fatalf("rule misses %s: %#v", key, rule)
+		}
+	}
+}
+
 func hasTag(outbounds []any, tag string) bool {
 	for _, outbound := range outbounds {
 		m, ok := outbound.(map[string]any)
@@ -111,3 +181,288 @@ func hasTag(outbounds []any, tag string) bool {
 	}
 	return false
 }
+
+func TestGenerateCoreInbounds(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	base := `{
+  "inbounds":[{"tag":"socks","protocol":"socks","listen":"127.0.0.1","port":10808}],
+  "outbounds":[{"protocol":"socks","tag":"proxy"},{"protocol":"freedom","tag":"direct"}],
+  "routing":{"rules":[{"type":"field","domain":["geosite:cn"],"outboundTag":"direct"}]}
+}`
+	if err := os.WriteFile(basePath, []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainCfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: outPath},
+		Xray: config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{
+			{Name: "active", Alias: "core-a", OutboundTag: "core-a", Listen: config.Listen{Host: "127.0.0.1", Port: 10001}, Active: true},
+			{Name: "c2", Alias: "core-b", Listen: config.Listen{Host: "127.0.0.1", Port: 10002}},
+		},
+		CoreInbounds: &config.CoreInbounds{BasePort: 20001, Protocol: "mixed"},
+	}
+	customRules := []map[string]any{{"type": "field", "domain": []string{"domain:example.com"}, "outboundTag": "core-b"}}
+	if err := Generate(mainCfg, nil, customRules); err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if outbounds := doc["outbounds"].([]any); !hasTag(outbounds, "core-a") || !hasTag(outbounds, "core-b") {
+		t.Fatalf("every core needs an outbound: %#v", outbounds)
+	}
+	inbounds := doc["inbounds"].([]any)
+	if len(inbounds) != 3 {
+		t.Fatalf("unexpected inbounds: %#v", inbounds)
+	}
+	for i, want := range []string{"core-a", "core-b"} {
+		in := inbounds[i+1].(map[string]any)
+		if in["tag"] != "core-in-"+want || in["port"] != float64(20001+i) || in["protocol"] != "mixed" || in["listen"] != "127.0.0.1" {
+			t.Fatalf("unexpected inbound for %s: %#v", want, in)
+		}
+	}
+	rules := doc["routing"].(map[string]any)["rules"].([]any)
+	if len(rules) != 4 {
+		t.Fatalf("unexpected rules: %#v", rules)
+	}
+	for i, want := range []string{"core-a", "core-b"} {
+		rule := rules[i].(map[string]any)
+		tags, _ := rule["inboundTag"].([]any)
+		if rule["outboundTag"] != want || len(tags) != 1 || tags[0] != "core-in-"+want {
+			t.Fatalf("rule %d should pin its inbound to %s: %#v", i, want, rule)
+		}
+	}
+
+	mainCfg.CoreInbounds.BasePort = 10808
+	if err := Generate(mainCfg, nil, nil); err == nil {
+		t.Fatal("expected error for a port taken by a base inbound")
+	}
+}
+
+func TestGenerateModes(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	base := `{
+  "outbounds":[{"protocol":"socks","tag":"proxy"},{"protocol":"freedom","tag":"direct"}],
+  "routing":{"rules":[
+    {"type":"field","inboundTag":["api"],"outboundTag":"api"},
+    {"type":"field","domain":["geosite:cn"],"outboundTag":"direct"}
+  ]}
+}`
+	if err := os.WriteFile(basePath, []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainCfg := &config.File{
+		App:   config.App{GeneratedXrayConfig: outPath},
+		Xray:  config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{{Name: "c1", Alias: "core-a", Listen: config.Listen{Host: "127.0.0.1", Port: 11080}}},
+	}
+	routingCfg := &config.Routing{
+		Rules:              []config.RoutingRule{{Name: "r1", Domain: []string{"domain:example.com"}, OutboundTag: "core-a"}},
+		DefaultOutboundTag: "core-a",
+	}
+	generate := func(mode string) []any {
+		t.Helper()
+		mainCfg.Mode = mode
+		if err := Generate(mainCfg, routingCfg, nil); err != nil {
+			t.Fatalf("generate %s: %v", mode, err)
+		}
+		b, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc["routing"].(map[string]any)["rules"].([]any)
+	}
+	last := func(rules []any) map[string]any {
+		return rules[len(rules)-1].(map[string]any)
+	}
+
+	rules := generate("")
+	if len(rules) != 4 || last(rules)["network"] != "tcp,udp" || last(rules)["outboundTag"] != "core-a" {
+		t.Fatalf("rule mode should end with a catch-all to the default: %#v", rules)
+	}
+	rules = generate(config.ModeGlobal)
+	if len(rules) != 2 || last(rules)["outboundTag"] != "proxy" || rules[0].(map[string]any)["outboundTag"] != "api" {
+		t.Fatalf("global mode should keep inbound rules and send the rest to proxy: %#v", rules)
+	}
+	rules = generate(config.ModeDirect)
+	if len(rules) != 2 || last(rules)["outboundTag"] != "direct" {
+		t.Fatalf("direct mode should send everything to the freedom outbound: %#v", rules)
+	}
+
+	routingCfg.GlobalOutboundTag = "missing"
+	mainCfg.Mode = config.ModeGlobal
+	if err := Generate(mainCfg, routingCfg, nil); err == nil {
+		t.Fatal("expected error for an unknown global outbound")
+	}
+}
+
+func TestGenerateGroups(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	base := `{
+  "outbounds":[{"protocol":"freedom","tag":"direct"}],
+  "observatory":{"subjectSelector":["direct"],"probeInterval":"5m"}
+}`
+	if err := os.WriteFile(basePath, []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainCfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: outPath},
+		Xray: config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{
+			{Name: "us1", Alias: "us1", Listen: config.Listen{Host: "127.0.0.1", Port: 11081}},
+			{Name: "us2", Alias: "us2", Listen: config.Listen{Host: "127.0.0.1", Port: 11082}},
+			{Name: "jp1", Alias: "jp1", Listen: config.Listen{Host: "127.0.0.1", Port: 11083}},
+		},
+		Groups: []config.Group{
+			{Name: "us-exits", Members: []string{"us1", "us2"}, Strategy: config.StrategyLeastPing, Fallback: "direct"},
+			{Name: "any", Members: []string{"us1", "jp1"}},
+		},
+	}
+	routingCfg := &config.Routing{
+		Rules: []config.RoutingRule{{Name: "us", Domain: []string{"domain:example.com"}, Group: "us-exits"}},
+	}
+	customRules := []map[string]any{{"type": "field", "domain": []string{"domain:example.org"}, "balancerTag": "any"}}
+	if err := Generate(mainCfg, routingCfg, customRules); err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	routing := doc["routing"].(map[string]any)
+	rules := routing["rules"].([]any)
+	if r := rules[1].(map[string]any); r["balancerTag"] != "us-exits" || r["outboundTag"] != nil {
+		t.Fatalf("group rule should target the balancer: %#v", r)
+	}
+	balancers := routing["balancers"].([]any)
+	if len(balancers) != 2 {
+		t.Fatalf("unexpected balancers: %#v", balancers)
+	}
+	us := balancers[0].(map[string]any)
+	if us["tag"] != "us-exits" || us["fallbackTag"] != "direct" || us["strategy"].(map[string]any)["type"] != "leastPing" {
+		t.Fatalf("unexpected balancer: %#v", us)
+	}
+	if other := balancers[1].(map[string]any); other["strategy"].(map[string]any)["type"] != "random" {
+		t.Fatalf("strategy should default to random: %#v", other)
+	}
+	obs := doc["observatory"].(map[string]any)
+	if got := obs["subjectSelector"].([]any); len(got) != 3 || got[0] != "direct" || obs["probeInterval"] != "5m" {
+		t.Fatalf("observatory should keep base settings and add leastPing members: %#v", obs)
+	}
+	burst := doc["burstObservatory"].(map[string]any)
+	if got := burst["subjectSelector"].([]any); len(got) != 2 || got[0] != "us1" || got[1] != "jp1" {
+		t.Fatalf("unexpected burst observatory: %#v", burst)
+	}
+
+	customRules[0]["balancerTag"] = "missing"
+	if err := Generate(mainCfg, routingCfg, customRules); err == nil {
+		t.Fatal("expected error for an unknown balancer")
+	}
+}
+
+func TestGenerateOutboundVia(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	if err := os.WriteFile(basePath, []byte(`{"outbounds":[{"protocol":"freedom","tag":"direct"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stream := map[string]any{"network": "ws", "sockopt": map[string]any{"mark": 255}}
+	mainCfg := &config.File{
+		App:   config.App{GeneratedXrayConfig: outPath},
+		Xray:  config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{{Name: "naive", Alias: "naive", Listen: config.Listen{Host: "127.0.0.1", Port: 11080}}},
+		Outbounds: []config.Outbound{
+			{Name: "vless", Tag: "vless", Via: "naive", Xray: map[string]any{"protocol": "vless", "streamSettings": stream}},
+		},
+	}
+	if err := Generate(mainCfg, nil, nil); err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	vless := doc["outbounds"].([]any)[2].(map[string]any)
+	got := vless["streamSettings"].(map[string]any)
+	sockopt := got["sockopt"].(map[string]any)
+	if got["network"] != "ws" || sockopt["dialerProxy"] != "naive" || sockopt["mark"] != float64(255) {
+		t.Fatalf("unexpected stream settings: %#v", got)
+	}
+	if _, ok := stream["sockopt"].(map[string]any)["dialerProxy"]; ok {
+		t.Fatal("config outbound should not be modified")
+	}
+}
+
+func TestGenerateCoreViaOutbound(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.json")
+	outPath := filepath.Join(tmp, "out.json")
+	base := `{
+  "inbounds":[{"tag":"socks","protocol":"socks","listen":"127.0.0.1","port":10870}],
+  "outbounds":[{"protocol":"freedom","tag":"direct"}]
+}`
+	if err := os.WriteFile(basePath, []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainCfg := &config.File{
+		App:  config.App{GeneratedXrayConfig: outPath},
+		Xray: config.Xray{BaseConfig: basePath},
+		Cores: []config.Core{
+			{Name: "tuic", Alias: "tuic", Listen: config.Listen{Host: "127.0.0.1", Port: 10871}, Via: "vless"},
+			{Name: "hy2", Alias: "hy2", Listen: config.Listen{Host: "127.0.0.1", Port: 10873}, Via: "vless"},
+		},
+		Outbounds: []config.Outbound{{Name: "vless", Tag: "vless", Xray: map[string]any{"protocol": "vless"}}},
+		Mode:      config.ModeDirect,
+	}
+	if err := Generate(mainCfg, nil, nil); err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	inbounds := doc["inbounds"].([]any)
+	if len(inbounds) != 2 {
+		t.Fatalf("want one via inbound shared by both cores: %#v", inbounds)
+	}
+	in := inbounds[1].(map[string]any)
+	if in["tag"] != "via-in-vless" || in["listen"] != "127.0.0.1" || in["port"] != float64(10872) || in["protocol"] != "socks" {
+		t.Fatalf("unexpected via inbound: %#v", in)
+	}
+	first := doc["routing"].(map[string]any)["rules"].([]any)[0].(map[string]any)
+	if first["outboundTag"] != "vless" || first["inboundTag"].([]any)[0] != "via-in-vless" {
+		t.Fatalf("via inbound should be pinned to its outbound in every mode: %#v", first)
+	}
+}